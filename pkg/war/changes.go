@@ -0,0 +1,228 @@
+package war
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	ChangeCreated  ChangeKind = "created"
+	ChangeModified ChangeKind = "modified"
+	ChangeRemoved  ChangeKind = "removed"
+	// ChangeRenamed is a move within the watched paths, From is the old path.
+	ChangeRenamed ChangeKind = "renamed"
+)
+
+// If the changed file list is larger than maxChangedFilesEnvSize, WAR_CHANGED_FILES is left empty,
+// use WAR_CHANGED_FILES_FILE instead.
+const maxChangedFilesEnvSize = 32 * 1024
+
+type (
+	ChangeKind string
+	Change     struct {
+		Path string     `json:"path"`
+		Kind ChangeKind `json:"kind"`
+		From string     `json:"from,omitempty"`
+	}
+	// changeSet 用于在 debounce 窗口内累积文件变化, 同一个路径只保留一条记录
+	changeSet struct {
+		m map[string]Change
+	}
+)
+
+func newChangeSet() *changeSet {
+	return &changeSet{m: make(map[string]Change)}
+}
+
+func (s *changeSet) add(path string, kind ChangeKind) {
+	last, ok := s.m[path]
+	if !ok {
+		s.m[path] = Change{Path: path, Kind: kind}
+		return
+	}
+	switch {
+	case (last.Kind == ChangeCreated || last.Kind == ChangeRenamed) && kind == ChangeModified:
+		// 新建或者移动之后再写, 仍然是新建或者移动
+	case last.Kind == ChangeCreated && kind == ChangeRemoved:
+		// 新建之后又删除, 相当于什么都没发生
+		delete(s.m, path)
+	case last.Kind == ChangeRenamed && kind == ChangeRemoved:
+		// 移动之后又删除, 相当于删除了原来的文件
+		delete(s.m, path)
+		s.add(last.From, ChangeRemoved)
+	case last.Kind == ChangeRemoved && kind == ChangeCreated:
+		s.m[path] = Change{Path: path, Kind: ChangeModified}
+	default:
+		s.m[path] = Change{Path: path, Kind: kind}
+	}
+}
+
+// rename records that from is moved to to.
+func (s *changeSet) rename(from string, to string) {
+	last, ok := s.m[from]
+	delete(s.m, from)
+	switch {
+	case ok && last.Kind == ChangeCreated:
+		// 新建之后再移动, 仍然是新建
+		s.m[to] = Change{Path: to, Kind: ChangeCreated}
+	case ok && last.Kind == ChangeRenamed && last.From == to:
+		// 移回了原来的位置
+		s.m[to] = Change{Path: to, Kind: ChangeModified}
+	case ok && last.Kind == ChangeRenamed:
+		s.m[to] = Change{Path: to, Kind: ChangeRenamed, From: last.From}
+	default:
+		s.m[to] = Change{Path: to, Kind: ChangeRenamed, From: from}
+	}
+}
+
+func (s *changeSet) apply(c Change) {
+	if c.Kind == ChangeRenamed {
+		s.rename(c.From, c.Path)
+	} else {
+		s.add(c.Path, c.Kind)
+	}
+}
+
+// merge 将 older 中的变化合并到 s 之前, s 中的变化更新
+func (s *changeSet) merge(older *changeSet) {
+	if older == nil {
+		return
+	}
+	merged := newChangeSet()
+	for _, c := range older.replay() {
+		merged.apply(c)
+	}
+	for _, c := range s.replay() {
+		merged.apply(c)
+	}
+	s.m = merged.m
+}
+
+// replay returns the changes in an order which rebuilds s when applied to an empty set.
+// 某个路径上的其他变化一定发生在它被移走之后, 因此 rename 排在前面;
+// 而 a -> b 和 b -> c 同时存在时, b -> c 一定先发生.
+func (s *changeSet) replay() []Change {
+	movedAway := make(map[string]bool)
+	for _, c := range s.m {
+		if c.Kind == ChangeRenamed {
+			movedAway[c.From] = true
+		}
+	}
+	order := func(c Change) int {
+		switch {
+		case c.Kind != ChangeRenamed:
+			return 2
+		case movedAway[c.Path]:
+			return 1
+		default:
+			return 0
+		}
+	}
+	ret := s.list()
+	sort.SliceStable(ret, func(i, j int) bool {
+		return order(ret[i]) < order(ret[j])
+	})
+	return ret
+}
+
+func (s *changeSet) len() int {
+	return len(s.m)
+}
+
+// list returns changes sorted by path.
+func (s *changeSet) list() []Change {
+	ret := make([]Change, 0, len(s.m))
+	for _, c := range s.m {
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Path < ret[j].Path
+	})
+	return ret
+}
+
+func changedPaths(changes []Change) []string {
+	ret := make([]string, 0, len(changes))
+	for _, c := range changes {
+		ret = append(ret, c.Path)
+	}
+	return ret
+}
+
+func changedPathsOf(changes []Change, kind ChangeKind) []string {
+	var ret []string
+	for _, c := range changes {
+		if c.Kind == kind {
+			ret = append(ret, c.Path)
+		}
+	}
+	return ret
+}
+
+// renamedPaths returns "from\tto" of the renamed changes.
+func renamedPaths(changes []Change) []string {
+	var ret []string
+	for _, c := range changes {
+		if c.Kind == ChangeRenamed {
+			ret = append(ret, c.From+"\t"+c.Path)
+		}
+	}
+	return ret
+}
+
+// changedEnv builds the WAR_CHANGED_* envs for a run.
+// The returned cleanup func removes the temp file, it is never nil.
+func changedEnv(changes []Change) (env []string, cleanup func(), err error) {
+	cleanup = func() {}
+	paths := strings.Join(changedPaths(changes), "\n")
+	f, err := os.CreateTemp("", "war-changed-*.txt")
+	if err != nil {
+		return nil, cleanup, err
+	}
+	cleanup = func() { os.Remove(f.Name()) }
+	_, err = f.WriteString(paths)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	env = append(env, "WAR_CHANGED_FILES_FILE="+f.Name())
+	// 列表太大时也要设置为空, 否则会继承 war 自己的环境变量中的值
+	values := [5]string{}
+	if len(paths) <= maxChangedFilesEnvSize {
+		values = [5]string{
+			paths,
+			strings.Join(changedPathsOf(changes, ChangeCreated), "\n"),
+			strings.Join(changedPathsOf(changes, ChangeModified), "\n"),
+			strings.Join(changedPathsOf(changes, ChangeRemoved), "\n"),
+			strings.Join(renamedPaths(changes), "\n"),
+		}
+	}
+	env = append(env,
+		"WAR_CHANGED_FILES="+values[0],
+		"WAR_CREATED_FILES="+values[1],
+		"WAR_MODIFIED_FILES="+values[2],
+		"WAR_REMOVED_FILES="+values[3],
+		"WAR_RENAMED_FILES="+values[4],
+	)
+	return env, cleanup, nil
+}
+
+// expandChanged replaces {{changed}} in cmd with the shell quoted changed paths.
+func expandChanged(cmd string, changes []Change) string {
+	if !strings.Contains(cmd, "{{changed}}") {
+		return cmd
+	}
+	quoted := make([]string, 0, len(changes))
+	for _, path := range changedPaths(changes) {
+		quoted = append(quoted, shellQuote(path))
+	}
+	return strings.ReplaceAll(cmd, "{{changed}}", strings.Join(quoted, " "))
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
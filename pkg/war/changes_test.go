@@ -0,0 +1,65 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestChangeSet(t *testing.T) {
+	s := newChangeSet()
+	s.add("/a.go", ChangeCreated)
+	s.add("/a.go", ChangeModified)
+	s.add("/b.go", ChangeModified)
+	s.add("/c.go", ChangeCreated)
+	s.add("/c.go", ChangeRemoved)
+	assert.Equal(t, []Change{{"/a.go", ChangeCreated, ""}, {"/b.go", ChangeModified, ""}}, s.list())
+
+	newer := newChangeSet()
+	newer.add("/b.go", ChangeRemoved)
+	newer.merge(s)
+	assert.Equal(t, []Change{{"/a.go", ChangeCreated, ""}, {"/b.go", ChangeRemoved, ""}}, newer.list())
+}
+
+func TestExpandChanged(t *testing.T) {
+	changes := []Change{{"/a b.go", ChangeModified, ""}, {"/it's.go", ChangeCreated, ""}}
+	assert.Equal(t, `golint '/a b.go' '/it'\''s.go'`, expandChanged("golint {{changed}}", changes))
+	assert.Equal(t, "go test ./...", expandChanged("go test ./...", changes))
+}
+
+func TestChangeSetRename(t *testing.T) {
+	s := newChangeSet()
+	s.rename("/a.go", "/b.go")
+	s.add("/b.go", ChangeModified)
+	assert.Equal(t, []Change{{"/b.go", ChangeRenamed, "/a.go"}}, s.list())
+	s.rename("/b.go", "/c.go")
+	assert.Equal(t, []Change{{"/c.go", ChangeRenamed, "/a.go"}}, s.list())
+	s.rename("/c.go", "/a.go")
+	assert.Equal(t, []Change{{"/a.go", ChangeModified, ""}}, s.list())
+
+	s = newChangeSet()
+	s.add("/d.go", ChangeCreated)
+	s.rename("/d.go", "/e.go")
+	s.rename("/a.go", "/b.go")
+	s.add("/b.go", ChangeRemoved)
+	assert.Equal(t, []Change{{"/a.go", ChangeRemoved, ""}, {"/e.go", ChangeCreated, ""}}, s.list())
+
+	// b -> c 先于 a -> b 发生, 然后又新建了 a
+	s = newChangeSet()
+	s.rename("/b.go", "/c.go")
+	s.rename("/a.go", "/b.go")
+	s.add("/a.go", ChangeCreated)
+	merged := newChangeSet()
+	merged.merge(s)
+	assert.Equal(t, s.list(), merged.list())
+}
+
+func TestChangedEnvTooLarge(t *testing.T) {
+	path := "/" + strings.Repeat("a", maxChangedFilesEnvSize)
+	env, cleanup, err := changedEnv([]Change{{path, ChangeCreated, ""}})
+	assert.NoError(t, err)
+	defer cleanup()
+	// 必须显式设置为空, 覆盖继承的值
+	assert.Contains(t, env, "WAR_CHANGED_FILES=")
+	assert.Contains(t, env, "WAR_CREATED_FILES=")
+}
@@ -0,0 +1,205 @@
+package war
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultTempFiles is the built-in catalog of editor/IDE temp files, matched against file names.
+// WithTempFiles extends it.
+var DefaultTempFiles = []string{
+	// backup files of vim, emacs, nano...
+	"*~",
+	// vim swap files and the file vim creates to test whether a dir is writable
+	"*.swp", "*.swo", "*.swx", "*.swpx", "4913",
+	// emacs auto-save and lock files
+	"#*#", ".#*",
+	// gedit and other GNOME apps
+	".goutputstream-*",
+	// JetBrains safe write
+	"*___jb_tmp___", "*___jb_old___",
+	// kate swap files
+	"*.kate-swp",
+	// LibreOffice and MS Office lock files
+	".~lock.*#", "~$*",
+	// chrome File System Access API
+	"*.crswap",
+}
+
+// vcsDirs are never watched unless they are listed by WithHiddenDirs, even if watch_hidden is true.
+var vcsDirs = []string{".git", ".hg", ".svn"}
+
+type (
+	// WatchDecision explains whether a path is watched.
+	WatchDecision struct {
+		Path    string
+		Watched bool
+		// Reason is a human readable explanation, it includes the location of Rule if Rule is not nil.
+		Reason string
+		// Rule is the ignore rule which decided it, it may be nil.
+		Rule *IgnoreRule
+	}
+)
+
+// String returns the location of rule, such as ".gitignore:3: *.log".
+func (r *IgnoreRule) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.LineNo, strings.TrimSpace(r.Line))
+}
+
+// CheckIgnore explains whether path is watched. A path inside an ignored dir is not watched.
+// It must not be called after Start.
+func (w *WatchAndRun) CheckIgnore(path string) WatchDecision {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return WatchDecision{Path: path, Reason: err.Error()}
+	}
+	if lo.Contains(w.options.envFiles, path) {
+		return WatchDecision{Path: path, Watched: true, Reason: "env file"}
+	}
+	r := w.rootOf(path)
+	if r == nil {
+		return WatchDecision{Path: path, Reason: "outside roots"}
+	}
+	if path == r.path {
+		return WatchDecision{Path: path, Watched: true, Reason: "root"}
+	}
+	if w.options.gitIgnore && !r.extra && r.gitIgnorer == nil {
+		r.gitIgnorer = NewGitIgnorer(r.path)
+	}
+	// 从 root 开始逐级检查父目录, 父目录被忽略的话, 它下面的所有文件都不会被监听
+	dir := r.path
+	for _, name := range strings.Split(filepath.Dir(r.rel(path)), "/") {
+		if r.gitIgnorer != nil {
+			r.gitIgnorer.LoadDir(dir)
+		}
+		if name == "." {
+			break
+		}
+		dir = filepath.Join(dir, name)
+		if d := w.checkDir(dir); !d.Watched {
+			d.Path = path
+			d.Reason = fmt.Sprintf("in ignored dir %s: %s", r.rel(dir), d.Reason)
+			return d
+		}
+	}
+	stat, err := os.Lstat(path)
+	switch {
+	case err == nil && stat.IsDir():
+		return w.checkDir(path)
+	case err == nil && stat.Mode()&os.ModeSymlink != 0:
+		if !w.options.followSymlinks {
+			return WatchDecision{Path: path, Reason: "symlinks are not watched (follow_symlinks is false)"}
+		}
+		if target, err := os.Stat(path); err != nil {
+			return WatchDecision{Path: path, Reason: "broken symlink"}
+		} else if target.IsDir() {
+			return w.checkDir(path)
+		}
+		return w.checkFile(path)
+	default:
+		// 不存在的文件当作普通文件检查, 和 git check-ignore 一样
+		return w.checkFile(path)
+	}
+}
+
+// checkDir checks a dir whose parent dirs are watched.
+func (w *WatchAndRun) checkDir(path string) WatchDecision {
+	r := w.rootOf(path)
+	if r == nil || r.file {
+		return WatchDecision{Path: path, Reason: "outside roots"}
+	}
+	rel := r.rel(path)
+	if name := filepath.Base(path); strings.HasPrefix(name, ".") {
+		switch {
+		case lo.Contains(w.options.hiddenDirs, name) || lo.Contains(w.options.hiddenDirs, rel):
+			// 显式允许的隐藏目录仍然要经过忽略规则
+		case lo.Contains(vcsDirs, name):
+			return WatchDecision{Path: path, Reason: fmt.Sprintf("vcs dir %s", name)}
+		case !w.options.watchHidden:
+			return WatchDecision{Path: path, Reason: fmt.Sprintf("hidden dir %s (watch_hidden is false)", name)}
+		}
+	}
+	return w.checkIgnoreRules(r, path, rel, true)
+}
+
+// checkFile checks a file whose parent dirs are watched, in the order:
+// env files are always watched, editor temp files are never watched,
+// then the file must match include_exts or include (if any of them is set),
+// at last ignore rules exclude the file.
+// Paths of extra_watch are not filtered by include_exts, include and the global ignore rules.
+func (w *WatchAndRun) checkFile(path string) WatchDecision {
+	if lo.Contains(w.options.envFiles, path) {
+		return WatchDecision{Path: path, Watched: true, Reason: "env file"}
+	}
+	r := w.rootOf(path)
+	if r == nil {
+		return WatchDecision{Path: path, Reason: "outside roots"}
+	}
+	if pattern, ok := w.matchTempFile(filepath.Base(path)); ok {
+		return WatchDecision{Path: path, Reason: fmt.Sprintf("editor temp file (matches %s)", pattern)}
+	}
+	if r.file {
+		return WatchDecision{Path: path, Watched: true, Reason: "root"}
+	}
+	rel := r.rel(path)
+	if !r.extra && !w.included(path, rel) {
+		return WatchDecision{Path: path, Reason: fmt.Sprintf("matches neither include_exts nor include (ext %q)", filepath.Ext(path))}
+	}
+	return w.checkIgnoreRules(r, path, rel, false)
+}
+
+// included reports whether the file matches include_exts or include, it is true if both are empty.
+func (w *WatchAndRun) included(path string, rel string) bool {
+	if len(w.options.includeExts) == 0 && len(w.options.include) == 0 {
+		return true
+	}
+	if _, ok := w.options.includeExts[filepath.Ext(path)]; ok {
+		return true
+	}
+	return lo.ContainsBy(w.options.include, func(pattern string) bool {
+		return matchGlob(pattern, rel)
+	})
+}
+
+func (w *WatchAndRun) matchTempFile(name string) (string, bool) {
+	for _, patterns := range [][]string{DefaultTempFiles, w.options.tempFiles} {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkIgnoreRules matches the git ignore sources, then the ignore rules of war.
+func (w *WatchAndRun) checkIgnoreRules(r *watchRoot, path string, rel string, isDir bool) WatchDecision {
+	var negated *IgnoreRule
+	if r.gitIgnorer != nil {
+		ignored, rule := r.gitIgnorer.Match(path, isDir)
+		if ignored {
+			return WatchDecision{Path: path, Reason: "ignored by " + rule.String(), Rule: rule}
+		}
+		negated = rule
+	}
+	if r.ignoreRules != nil {
+		if rule := r.ignoreRules.match(path, isDir); rule != nil {
+			if !rule.negate {
+				return WatchDecision{Path: path, Reason: "ignored by " + rule.String(), Rule: rule}
+			}
+			negated = rule
+		}
+	}
+	if w.options.ignore != nil && !r.extra {
+		if w.options.ignore.MatchesPath(rel) || (isDir && w.options.ignore.MatchesPath(rel+"/")) {
+			return WatchDecision{Path: path, Reason: "ignored by ignore rules"}
+		}
+	}
+	if negated != nil {
+		return WatchDecision{Path: path, Watched: true, Reason: "not ignored by " + negated.String(), Rule: negated}
+	}
+	return WatchDecision{Path: path, Watched: true, Reason: "no ignore rule matches"}
+}
@@ -0,0 +1,112 @@
+package war
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckIgnore(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "a", "build"), 0755))
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithIncludeExts([]string{".go", ".tmp"}),
+		WithIgnoreRules("rules", []string{"/build", "*.tmp", "!keep.tmp"}),
+	)
+	assert.NoError(t, err)
+
+	d := w.CheckIgnore(filepath.Join(root, "build", "main.go"))
+	assert.False(t, d.Watched)
+	assert.Equal(t, 1, d.Rule.LineNo)
+	// 带 / 前缀的规则只匹配 root 下的 build
+	assert.True(t, w.CheckIgnore(filepath.Join(root, "a", "build", "main.go")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, "a", "x.tmp")).Watched)
+	d = w.CheckIgnore(filepath.Join(root, "a", "keep.tmp"))
+	assert.True(t, d.Watched)
+	assert.Equal(t, 3, d.Rule.LineNo)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, "a", "x.txt")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, ".git", "x.go")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Dir(root)).Watched)
+}
+
+func TestCheckIgnoreHiddenAndTempFiles(t *testing.T) {
+	root := t.TempDir()
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithHiddenDirs([]string{".github", "web/.storybook"}),
+		WithTempFiles([]string{"*.bak"}),
+	)
+	assert.NoError(t, err)
+	assert.True(t, w.CheckIgnore(filepath.Join(root, ".github", "ci.yml")).Watched)
+	assert.True(t, w.CheckIgnore(filepath.Join(root, "web", ".storybook", "main.js")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, "a", ".storybook", "main.js")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, ".config", "x")).Watched)
+	assert.True(t, w.CheckIgnore(filepath.Join(root, ".env")).Watched)
+	for _, name := range []string{"a.go~", ".a.go.swp", "4913", "#a.go#", ".#a.go", ".goutputstream-X1", "a.go___jb_tmp___", "a.bak"} {
+		assert.False(t, w.CheckIgnore(filepath.Join(root, name)).Watched, name)
+	}
+
+	w, err = NewWatchAndRun(WithRoot(root), WithWatchHidden(true))
+	assert.NoError(t, err)
+	assert.True(t, w.CheckIgnore(filepath.Join(root, ".config", "x")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, ".git", "HEAD")).Watched)
+}
+
+func TestCheckIgnoreRoots(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "app")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "etc"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "etc", "app.yaml"), nil, 0644))
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithIncludeExts([]string{".go"}),
+		WithIgnoreRules("rules", []string{"*_test.go"}),
+		WithRoots([]WatchPath{{Path: "../lib", Ignore: []string{"/gen"}}}),
+		WithExtraWatch([]WatchPath{{Path: filepath.Join(dir, "etc", "app.yaml")}, {Path: "../conf", Ignore: []string{"*.bak"}}}),
+	)
+	assert.NoError(t, err)
+	assert.True(t, w.CheckIgnore(filepath.Join(dir, "lib", "a.go")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(dir, "lib", "a_test.go")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(dir, "lib", "gen", "a.go")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(dir, "lib", "a.txt")).Watched)
+	// extra_watch 不受 include_exts 和全局忽略规则的影响
+	assert.True(t, w.CheckIgnore(filepath.Join(dir, "conf", "a.yaml")).Watched)
+	assert.True(t, w.CheckIgnore(filepath.Join(dir, "conf", "a_test.go")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(dir, "conf", "a.bak")).Watched)
+	assert.True(t, w.CheckIgnore(filepath.Join(dir, "etc", "app.yaml")).Watched)
+	assert.False(t, w.CheckIgnore(filepath.Join(dir, "etc", "other.yaml")).Watched)
+}
+
+func TestCheckIgnoreSymlinks(t *testing.T) {
+	root := t.TempDir()
+	target := t.TempDir()
+	assert.NoError(t, os.Symlink(target, filepath.Join(root, "pkg")))
+	w, err := NewWatchAndRun(WithRoot(root), WithIgnoreRules("rules", []string{"pkg/gen/"}))
+	assert.NoError(t, err)
+	assert.False(t, w.CheckIgnore(filepath.Join(root, "pkg")).Watched)
+
+	w, err = NewWatchAndRun(WithRoot(root), WithFollowSymlinks(true), WithIgnoreRules("rules", []string{"pkg/gen/"}))
+	assert.NoError(t, err)
+	assert.True(t, w.CheckIgnore(filepath.Join(root, "pkg")).Watched)
+	assert.True(t, w.CheckIgnore(filepath.Join(root, "pkg", "a.go")).Watched)
+	// 忽略规则匹配的是 symlink 的路径
+	assert.False(t, w.CheckIgnore(filepath.Join(root, "pkg", "gen", "a.go")).Watched)
+
+	id1, err := dirID(target)
+	assert.NoError(t, err)
+	id2, err := dirID(filepath.Join(root, "pkg"))
+	assert.NoError(t, err)
+	assert.Equal(t, id1, id2)
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	// check-ignore 只用 CheckIgnore, 不 Start
+	w, err := NewWatchAndRun(WithRoot(t.TempDir()))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Stop(context.Background()))
+	assert.NoError(t, w.Stop(context.Background()))
+	assert.Error(t, w.watcher.Add(w.options.root))
+}
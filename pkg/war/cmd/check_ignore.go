@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/xzchaoo/watch-and-run/pkg/war"
+)
+
+var checkIgnoreCmd = &cobra.Command{
+	Use:   "check-ignore <path>...",
+	Short: "Explain whether paths are watched",
+	Example: `  # Explain with the ignore settings of a config file
+  war check-ignore -c war.toml build/out.log src/main.go
+
+  # Explain in auto mode, which respects .gitignore files
+  war check-ignore --auto build/`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, root, cfgDir, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		opts, err := watchOptions(cfg, root, cfgDir)
+		if err != nil {
+			return err
+		}
+		w, err := war.NewWatchAndRun(opts...)
+		if err != nil {
+			return err
+		}
+		defer w.Stop(context.Background())
+		for _, path := range args {
+			d := w.CheckIgnore(path)
+			status := "watched"
+			if !d.Watched {
+				status = "ignored"
+			}
+			fmt.Printf("%s\t%s\t%s\n", status, path, d.Reason)
+		}
+		return nil
+	},
+}
+
+func init() {
+	checkIgnoreCmd.Flags().StringVarP(&cfgPath, "config", "c", "", "config file")
+	checkIgnoreCmd.Flags().StringVarP(&fRoot, "root", "", "", "watch root")
+	checkIgnoreCmd.Flags().BoolVarP(&fAuto, "auto", "", false, "auto mode")
+	checkIgnoreCmd.Flags().StringSliceVarP(&fIgnore, "ignore", "i", nil, "ignore pattern")
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -40,15 +41,29 @@ var fDelay time.Duration
 // If fCancelLast is false, it will wait until the last ongoing running process finishes before it starts execution.
 var fCancelLast bool
 
+// fQueueMode describes what happens to file changes during a run, it overrides fCancelLast:
+// restart: cancel the current run and run again (the same as fCancelLast is true)
+// queue: wait until the current run finishes, then run once more with all accumulated changes
+// drop: ignore changes during a run
+var fQueueMode string
+
 // If the SIGTERM signal fails to stop the run process group within the specified time, then the SIGKILL signal will be sent to the run process group.
 // If fTermTimeout is zero, then the SIGKILL signal will be sent directly to the run process group.
 var fTermTimeout time.Duration
 
+// If a run command does not finish within fTimeout, it is stopped like a cancellation and logged as a timeout.
+// If fTimeout is zero, there is no timeout.
+var fTimeout time.Duration
+
+// The shell used to run commands: sh, bash, zsh, fish or none.
+// If fShell is none, commands are split into argv and executed directly, so that signals reach the real process.
+var fShell string
+
 var rootCmd = &cobra.Command{
 	Use: "war",
 	Example: `  # auto mode
   # It use current working directory as root if it is not set.
-  # It automatically loads the $root/.gitignore as ignore file if it exists.
+  # It automatically respects .gitignore files (nested ones included), .git/info/exclude and core.excludesFile.
   # It automatically uses the $root/war_run.sh as run command if it exists and run command is empty.
   # It automatically uses the $root/run.sh as run command if it exists and run command is empty.
   war --auto`,
@@ -60,104 +75,62 @@ var rootCmd = &cobra.Command{
 		if len(args) == 1 {
 			cfgPath = args[0]
 		}
-		wd, err := os.Getwd()
+		cfg, root, cfgDir, err := loadConfig()
 		if err != nil {
-			return fmt.Errorf("get wd error: %+v", err)
-		}
-
-		cfg := war.Config{}
-		var cfgDir string
-		root := fRoot
-		var run []string
-		var ignoreLines []string
-
-		if cfgPath != "" {
-			if _, err = toml.DecodeFile(cfgPath, &cfg); err != nil {
-				return fmt.Errorf("read config file error: %+v", err)
-			}
-			if cfgDir, err = filepath.Abs(filepath.Dir(cfgPath)); err != nil {
-				return fmt.Errorf("get config dir error: %+v", err)
-			}
-			if root == "" {
-				root = cfg.Root
-				if root == "" {
-					root = wd
-				} else if filepath.IsAbs(root) {
-					root = cfg.Root
-				} else if strings.HasPrefix(root, "wd:") {
-					// wd:${relativePath}
-					root = filepath.Join(wd, root[len("wd:"):])
-				} else if strings.HasPrefix(root, "cfg:") {
-					// cfg:${relativePath}
-					root = filepath.Join(cfgDir, root[len("cfg:"):])
-				} else if strings.HasPrefix(root, "env:") {
-					// env:project_root
-					root = os.Getenv(root[len("env:"):])
-				} else {
-					root = filepath.Join(wd, root)
-				}
-			}
-			run = convertToStringSlice(cfg.Run)
-			if cfg.IgnoreFile != "" {
-				bs, err := os.ReadFile(cfg.IgnoreFile)
-				if err != nil {
-					return err
-				}
-				ignoreLines = append(ignoreLines, strings.Split(string(bs), "\n")...)
-			}
-			ignoreLines = append(ignoreLines, cfg.IgnoreRules...)
-		}
-		if root == "" {
-			root = wd
+			return err
 		}
 		log.Println(color.YellowString("root=[%s]", root))
+		run, err := convertToRunConfigs(cfg.Run)
+		if err != nil {
+			return fmt.Errorf("parse run error: %+v", err)
+		}
 
-		run = append(run, lo.Map(fRun, func(s string, _ int) string {
-			return lo.Ternary(filepath.IsAbs(s), s, filepath.Join(root, s))
+		run = append(run, lo.Map(fRun, func(s string, _ int) war.RunConfig {
+			return war.RunConfig{Cmd: lo.Ternary(filepath.IsAbs(s), s, filepath.Join(root, s))}
 		})...)
 
 		if fAuto {
 			// auto mode
-			{
-				path := filepath.Join(root, ".gitignore")
-				if bs, err := os.ReadFile(path); err == nil {
-					ignoreLines = append(ignoreLines, strings.Split(string(bs), "\n")...)
-					log.Println(color.YellowString("[auto] load ignore file from %s", path))
-				}
-			}
+			log.Println(color.YellowString("[auto] respect .gitignore files"))
 			if len(run) == 0 {
 				path := filepath.Join(root, "war_run.sh")
 				if _, err := os.Stat(path); err == nil {
 					log.Println(color.YellowString("[auto] detect war_run.sh"))
-					run = append(run, path)
+					run = append(run, war.RunConfig{Cmd: path})
 				}
 			}
 			if len(run) == 0 {
 				path := filepath.Join(root, "run.sh")
 				if _, err := os.Stat(path); err == nil {
 					log.Println(color.YellowString("[auto] detect run.sh"))
-					run = append(run, path)
+					run = append(run, war.RunConfig{Cmd: path})
 				}
 			}
 		}
 
 		if len(fIgnore) > 0 {
 			log.Println(color.YellowString("add ignore: %s", fIgnore))
-			ignoreLines = append(ignoreLines, fIgnore...)
 		}
 		if len(run) == 0 {
 			return errors.New("run is empty, use -r to specify the run command")
 		}
-		ignore := gitignore.CompileIgnoreLines(ignoreLines...)
-		opts := []war.Option{
-			war.WithRoot(root),                   //
-			war.WithCfgDir(cfgDir),               //
-			war.WithRun(run),                     //
-			war.WithIgnore(ignore),               //
-			war.WithIncludeExts(cfg.IncludeExts), //
-			war.WithEnv(cfg.Env),                 //
-			war.WithLogLevel(fLogLevel),          //
+		commands, err := convertToCommands(run, cfg.ContinueOnTimeout)
+		if err != nil {
+			return err
+		}
+		opts, err := watchOptions(cfg, root, cfgDir)
+		if err != nil {
+			return err
 		}
+		opts = append(opts,
+			war.WithCommands(commands),             //
+			war.WithEnv(cfg.Env),                   //
+			war.WithPty(cfg.Pty),                   //
+			war.WithForwardStdin(cfg.ForwardStdin), //
+			war.WithLogLevel(fLogLevel),            //
+			war.WithStream(cfg.Stream),             //
+			war.WithReap(cfg.Reap),                 //
+		)
 
 		if cmd.Flag("delay").Changed {
 			d := war.Duration(fDelay)
@@ -171,15 +144,67 @@ var rootCmd = &cobra.Command{
 			d := war.Duration(fTermTimeout)
 			cfg.TermTimeout = &d
 		}
-		if cfg.Delay != nil {
-			opts = append(opts, war.WithDelay(time.Duration(*cfg.Delay)))
+		timing := war.Timing{
+			Delay:       time.Duration(lo.FromPtrOr(cfg.Delay, war.Duration(fDelay))),
+			MaxWait:     time.Duration(lo.FromPtr(cfg.MaxWait)),
+			Leading:     cfg.Leading,
+			MinInterval: time.Duration(lo.FromPtr(cfg.MinInterval)),
 		}
+		opts = append(opts,
+			war.WithDelay(timing.Delay),
+			war.WithMaxWait(timing.MaxWait),
+			war.WithLeading(timing.Leading),
+			war.WithMinInterval(timing.MinInterval),
+			war.WithTimingRules(convertToTimingRules(cfg.Timing, timing)),
+		)
 		if cfg.CancelLast != nil {
 			opts = append(opts, war.WithCancelLast(*cfg.CancelLast))
 		}
+		if cmd.Flag("queue-mode").Changed {
+			cfg.QueueMode = fQueueMode
+		}
+		if cfg.QueueMode != "" {
+			if !lo.Contains([]string{war.QueueRestart, war.QueueQueue, war.QueueDrop}, cfg.QueueMode) {
+				return fmt.Errorf("unsupported queue mode: %s", cfg.QueueMode)
+			}
+			opts = append(opts, war.WithQueueMode(cfg.QueueMode))
+		}
 		if cfg.TermTimeout != nil {
 			opts = append(opts, war.WithTermTimeout(time.Duration(*cfg.TermTimeout)))
 		}
+		if len(cfg.StopSignals) > 0 {
+			var steps []war.StopStep
+			for _, s := range cfg.StopSignals {
+				step, err := war.ParseStopStep(s)
+				if err != nil {
+					return err
+				}
+				steps = append(steps, step)
+			}
+			opts = append(opts, war.WithStopSteps(steps))
+		}
+		if cmd.Flag("timeout").Changed {
+			d := war.Duration(fTimeout)
+			cfg.Timeout = &d
+		}
+		if cfg.Timeout != nil {
+			opts = append(opts, war.WithTimeout(time.Duration(*cfg.Timeout)))
+		}
+		if cmd.Flag("shell").Changed {
+			cfg.Shell = fShell
+		}
+		if cfg.Shell != "" {
+			if err := war.ValidateShell(cfg.Shell); err != nil {
+				return err
+			}
+			opts = append(opts, war.WithShell(cfg.Shell))
+		}
+		if cfg.PortTimeout != nil {
+			opts = append(opts, war.WithPortTimeout(time.Duration(*cfg.PortTimeout)))
+		}
+		if cfg.StopGroup != nil {
+			opts = append(opts, war.WithStopGroup(*cfg.StopGroup))
+		}
 		w, err := war.NewWatchAndRun(opts...) //
 		if err != nil {
 			return err
@@ -189,15 +214,23 @@ var rootCmd = &cobra.Command{
 		}
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, os.Interrupt)
-		sig := <-sigCh
-		log.Printf("receive %s", sig)
-		signal.Stop(sigCh)
-		return w.Stop(context.Background())
+		defer signal.Stop(sigCh)
+		select {
+		case sig := <-sigCh:
+			log.Printf("receive %s", sig)
+			return w.Stop(context.Background())
+		case err := <-w.Failed():
+			w.Stop(context.Background())
+			// 不是用法错误, 不打印 usage
+			cmd.SilenceUsage = true
+			return err
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(exampleCmd)
+	rootCmd.AddCommand(checkIgnoreCmd)
 	rootCmd.Flags().StringVarP(&cfgPath, "config", "c", "", "config file")
 	rootCmd.Flags().StringVarP(&fRoot, "root", "", "", "watch root")
 	rootCmd.Flags().StringSliceVarP(&fRun, "run", "r", nil, "run cmd")
@@ -206,22 +239,261 @@ func init() {
 	rootCmd.Flags().StringSliceVarP(&fIgnore, "ignore", "i", nil, "ignore pattern")
 	rootCmd.Flags().DurationVarP(&fDelay, "delay", "d", time.Second, "run delay")
 	rootCmd.Flags().BoolVarP(&fAuto, "cancel-last", "", true, "cancel the last run if it has not already been stopped")
+	rootCmd.Flags().StringVarP(&fQueueMode, "queue-mode", "", "", "what happens to changes during a run (restart, queue, drop)")
 	rootCmd.Flags().DurationVarP(&fTermTimeout, "term-timeout", "", time.Second, "SIGTERM timeout")
+	rootCmd.Flags().StringVarP(&fShell, "shell", "", war.DefaultShell, "shell used to run commands (sh, bash, zsh, fish, none)")
+	rootCmd.Flags().DurationVarP(&fTimeout, "timeout", "", 0, "default timeout of run commands (0: no timeout)")
 }
 
 func Execute() {
 	rootCmd.Execute()
 }
 
-func convertToStringSlice(a any) []string {
-	var ret []string
+// loadConfig reads the config file if cfgPath is set, and resolves the root dir.
+func loadConfig() (cfg war.Config, root string, cfgDir string, err error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return cfg, "", "", fmt.Errorf("get wd error: %+v", err)
+	}
+	root = fRoot
+	if cfgPath != "" {
+		if _, err = toml.DecodeFile(cfgPath, &cfg); err != nil {
+			return cfg, "", "", fmt.Errorf("read config file error: %+v", err)
+		}
+		if cfgDir, err = filepath.Abs(filepath.Dir(cfgPath)); err != nil {
+			return cfg, "", "", fmt.Errorf("get config dir error: %+v", err)
+		}
+		if root == "" {
+			root = cfg.Root
+			if root == "" {
+				root = wd
+			} else if filepath.IsAbs(root) {
+				root = cfg.Root
+			} else if strings.HasPrefix(root, "wd:") {
+				// wd:${relativePath}
+				root = filepath.Join(wd, root[len("wd:"):])
+			} else if strings.HasPrefix(root, "cfg:") {
+				// cfg:${relativePath}
+				root = filepath.Join(cfgDir, root[len("cfg:"):])
+			} else if strings.HasPrefix(root, "env:") {
+				// env:project_root
+				root = os.Getenv(root[len("env:"):])
+			} else {
+				root = filepath.Join(wd, root)
+			}
+		}
+	}
+	if root == "" {
+		root = wd
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return cfg, "", "", err
+	}
+	if fAuto {
+		cfg.GitIgnore = true
+	}
+	return cfg, root, cfgDir, nil
+}
+
+// watchOptions builds the options deciding which files are watched.
+func watchOptions(cfg war.Config, root string, cfgDir string) ([]war.Option, error) {
+	roots, err := convertToWatchPaths(cfg.Roots)
+	if err != nil {
+		return nil, fmt.Errorf("parse roots error: %+v", err)
+	}
+	extraWatch, err := convertToWatchPaths(cfg.ExtraWatch)
+	if err != nil {
+		return nil, fmt.Errorf("parse extra_watch error: %+v", err)
+	}
+	opts := []war.Option{
+		war.WithRoot(root),                         //
+		war.WithRoots(roots),                       //
+		war.WithExtraWatch(extraWatch),             //
+		war.WithCfgDir(cfgDir),                     //
+		war.WithIncludeExts(cfg.IncludeExts),       //
+		war.WithInclude(cfg.Include),               //
+		war.WithEnvFiles(cfg.EnvFiles),             //
+		war.WithGitIgnore(cfg.GitIgnore),           //
+		war.WithWatchHidden(cfg.WatchHidden),       //
+		war.WithHiddenDirs(cfg.HiddenDirs),         //
+		war.WithTempFiles(cfg.TempFiles),           //
+		war.WithFollowSymlinks(cfg.FollowSymlinks), //
+	}
+	if cfg.WatchLimitFallback != "" {
+		if err := war.ValidateWatchLimitFallback(cfg.WatchLimitFallback); err != nil {
+			return nil, err
+		}
+		opts = append(opts, war.WithWatchLimitFallback(cfg.WatchLimitFallback))
+	}
+	if cfg.Backend != "" {
+		if err := war.ValidateBackend(cfg.Backend); err != nil {
+			return nil, err
+		}
+		opts = append(opts, war.WithBackend(cfg.Backend))
+	}
+	if cfg.PollInterval != nil {
+		opts = append(opts, war.WithPollInterval(time.Duration(*cfg.PollInterval)))
+	}
+	for _, pattern := range cfg.Include {
+		if err := war.ValidateGlob(pattern); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.IgnoreFile != "" {
+		bs, err := os.ReadFile(cfg.IgnoreFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, war.WithIgnoreRules(cfg.IgnoreFile, strings.Split(string(bs), "\n")))
+	}
+	if len(cfg.IgnoreRules) > 0 {
+		source := "ignore_rules"
+		if cfgPath != "" {
+			source = cfgPath + " ignore_rules"
+		}
+		opts = append(opts, war.WithIgnoreRules(source, cfg.IgnoreRules))
+	}
+	if len(fIgnore) > 0 {
+		opts = append(opts, war.WithIgnoreRules("--ignore", fIgnore))
+	}
+	return opts, nil
+}
+
+// convertToRunConfigs converts run (string, []string or tables mixed with string) to []war.RunConfig.
+func convertToRunConfigs(a any) ([]war.RunConfig, error) {
+	var items []any
 	switch x := a.(type) {
-	case string:
-		ret = []string{x}
+	case nil:
+		return nil, nil
 	case []any:
-		ret = lo.Map(x, func(item any, _ int) string {
-			return item.(string)
-		})
+		items = x
+	default:
+		items = []any{x}
+	}
+	var ret []war.RunConfig
+	for _, item := range items {
+		switch x := item.(type) {
+		case string:
+			ret = append(ret, war.RunConfig{Cmd: x})
+		case map[string]any:
+			// 先编码回 toml 再解码到结构体, 这样就不用手动处理每个字段了
+			var buf bytes.Buffer
+			if err := toml.NewEncoder(&buf).Encode(x); err != nil {
+				return nil, err
+			}
+			rc := war.RunConfig{}
+			if _, err := toml.Decode(buf.String(), &rc); err != nil {
+				return nil, err
+			}
+			if rc.Cmd == "" {
+				return nil, errors.New("cmd of run is empty")
+			}
+			ret = append(ret, rc)
+		default:
+			return nil, fmt.Errorf("unsupported run: %v", item)
+		}
 	}
-	return ret
+	return ret, nil
+}
+
+// convertToWatchPaths converts roots or extra_watch (string, []string or tables mixed with string) to []war.WatchPath.
+func convertToWatchPaths(a any) ([]war.WatchPath, error) {
+	var items []any
+	switch x := a.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		items = x
+	default:
+		items = []any{x}
+	}
+	var ret []war.WatchPath
+	for _, item := range items {
+		switch x := item.(type) {
+		case string:
+			ret = append(ret, war.WatchPath{Path: x})
+		case map[string]any:
+			var buf bytes.Buffer
+			if err := toml.NewEncoder(&buf).Encode(x); err != nil {
+				return nil, err
+			}
+			c := war.WatchPathConfig{}
+			if _, err := toml.Decode(buf.String(), &c); err != nil {
+				return nil, err
+			}
+			if c.Path == "" {
+				return nil, errors.New("path is empty")
+			}
+			ret = append(ret, war.WatchPath{Path: c.Path, Ignore: c.Ignore})
+		default:
+			return nil, fmt.Errorf("unsupported path: %v", item)
+		}
+	}
+	return ret, nil
+}
+
+func convertToCommands(run []war.RunConfig, continueOnTimeout bool) ([]war.Command, error) {
+	var ret []war.Command
+	for _, rc := range run {
+		command := war.Command{
+			Cmd:               rc.Cmd,
+			StopCmd:           rc.StopCmd,
+			Ports:             rc.Ports,
+			ContinueOnTimeout: lo.FromPtrOr(rc.ContinueOnTimeout, continueOnTimeout),
+			Shell:             rc.Shell,
+			Dir:               rc.Dir,
+			Env:               rc.Env,
+			Stdin:             rc.Stdin,
+		}
+		if err := war.ValidateStdin(rc.Stdin); err != nil {
+			return nil, err
+		}
+		if rc.Shell != "" {
+			if err := war.ValidateShell(rc.Shell); err != nil {
+				return nil, err
+			}
+		}
+		if rc.ReloadSignal != "" {
+			sig, err := war.ParseSignal(rc.ReloadSignal)
+			if err != nil {
+				return nil, err
+			}
+			command.ReloadSignal = sig
+		}
+		if rc.Memory != "" {
+			memory, err := war.ParseSize(rc.Memory)
+			if err != nil {
+				return nil, err
+			}
+			command.Limits.Memory = memory
+		}
+		if rc.CPUTime != nil {
+			command.Limits.CPUTime = time.Duration(*rc.CPUTime)
+		}
+		if rc.Timeout != nil {
+			// timeout = "0s" 表示这个命令不使用全局的 timeout
+			command.Limits.Timeout = lo.Ternary(*rc.Timeout > 0, time.Duration(*rc.Timeout), war.NoTimeout)
+		}
+		command.Limits.OpenFiles = rc.OpenFiles
+		if len(rc.ReloadOn) > 0 {
+			command.ReloadOn = gitignore.CompileIgnoreLines(rc.ReloadOn...)
+		}
+		ret = append(ret, command)
+	}
+	return ret, nil
+}
+
+// convertToTimingRules converts timing configs to rules, unset fields fall back to global.
+func convertToTimingRules(configs []war.TimingConfig, global war.Timing) []war.TimingRule {
+	return lo.Map(configs, func(c war.TimingConfig, _ int) war.TimingRule {
+		return war.TimingRule{
+			Match: gitignore.CompileIgnoreLines(c.Match...),
+			Timing: war.Timing{
+				Delay:       lo.Ternary(c.Delay != nil, time.Duration(lo.FromPtr(c.Delay)), global.Delay),
+				MaxWait:     lo.Ternary(c.MaxWait != nil, time.Duration(lo.FromPtr(c.MaxWait)), global.MaxWait),
+				Leading:     lo.FromPtrOr(c.Leading, global.Leading),
+				MinInterval: lo.Ternary(c.MinInterval != nil, time.Duration(lo.FromPtr(c.MinInterval)), global.MinInterval),
+			},
+		}
+	})
 }
@@ -0,0 +1,159 @@
+package war
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseDotenv parses the content of a .env file.
+// Comments, the export prefix, single quotes, double quotes and ${VAR} expansion are supported.
+// lookup is used to expand variables which are not defined before in the same file.
+func parseDotenv(content string, lookup func(string) string) ([][2]string, error) {
+	var ret [][2]string
+	defined := make(map[string]string)
+	mapping := func(key string) string {
+		if v, ok := defined[key]; ok {
+			return v
+		}
+		return lookup(key)
+	}
+	line := 1
+	for i := 0; i < len(content); {
+		// 跳过空白和注释
+		switch c := content[i]; {
+		case c == '\n':
+			line++
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		case c == '#':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			continue
+		}
+		eq := strings.IndexByte(content[i:], '=')
+		nl := strings.IndexByte(content[i:], '\n')
+		if eq < 0 || (nl >= 0 && nl < eq) {
+			return nil, fmt.Errorf("line %d: missing =", line)
+		}
+		key := strings.TrimSpace(content[i : i+eq])
+		if fields := strings.Fields(key); len(fields) == 2 && fields[0] == "export" {
+			key = fields[1]
+		}
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: invalid key %q", line, key)
+		}
+		i += eq + 1
+		for i < len(content) && (content[i] == ' ' || content[i] == '\t') {
+			i++
+		}
+		var value strings.Builder
+		if i < len(content) && content[i] == '\'' {
+			end := strings.IndexByte(content[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", line)
+			}
+			value.WriteString(content[i+1 : i+1+end])
+			line += strings.Count(content[i+1:i+1+end], "\n")
+			i += end + 2
+		} else if i < len(content) && content[i] == '"' {
+			i++
+			for ; i < len(content) && content[i] != '"'; i++ {
+				switch c := content[i]; {
+				case c == '\\' && i+1 < len(content):
+					i++
+					switch content[i] {
+					case 'n':
+						value.WriteByte('\n')
+					case 't':
+						value.WriteByte('\t')
+					case 'r':
+						value.WriteByte('\r')
+					default:
+						value.WriteByte(content[i])
+					}
+				case c == '$':
+					i += expandVar(content[i:], mapping, &value) - 1
+				default:
+					if c == '\n' {
+						line++
+					}
+					value.WriteByte(c)
+				}
+			}
+			if i >= len(content) {
+				return nil, errors.New("unterminated double quote")
+			}
+			i++
+		} else {
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				end = len(content) - i
+			}
+			raw := content[i : i+end]
+			// 未加引号的值, " #" 之后是注释
+			if j := strings.Index(raw, " #"); j >= 0 {
+				raw = raw[:j]
+			}
+			raw = strings.TrimSpace(raw)
+			for j := 0; j < len(raw); j++ {
+				if raw[j] == '$' {
+					j += expandVar(raw[j:], mapping, &value) - 1
+				} else {
+					value.WriteByte(raw[j])
+				}
+			}
+			i += end
+		}
+		// 值后面只能跟注释
+		for i < len(content) && content[i] != '\n' {
+			if content[i] == '#' {
+				for i < len(content) && content[i] != '\n' {
+					i++
+				}
+				break
+			}
+			if content[i] != ' ' && content[i] != '\t' && content[i] != '\r' {
+				return nil, fmt.Errorf("line %d: unexpected content after value", line)
+			}
+			i++
+		}
+		defined[key] = value.String()
+		ret = append(ret, [2]string{key, value.String()})
+	}
+	return ret, nil
+}
+
+// loadEnvFiles loads env files in order, later files take precedence. Missing files are skipped.
+func loadEnvFiles(paths []string) ([]string, error) {
+	var env []string
+	defined := make(map[string]string)
+	for _, path := range paths {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		kvs, err := parseDotenv(string(bs), func(key string) string {
+			if v, ok := defined[key]; ok {
+				return v
+			}
+			return os.Getenv(key)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("parse %s error: %v", path, err)
+		}
+		for _, kv := range kvs {
+			defined[kv[0]] = kv[1]
+			env = append(env, kv[0]+"="+kv[1])
+		}
+	}
+	return env, nil
+}
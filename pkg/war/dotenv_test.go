@@ -0,0 +1,80 @@
+package war
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDotenv(t *testing.T) {
+	content := `# comment
+FOO=foo # inline comment
+export BAR = 'bar $FOO'
+BAZ="${FOO}-$HOME\n\"q\"" # comment
+EMPTY=
+MULTI="a
+b"
+URL=http://x#y
+export	TAB=1
+`
+	kvs, err := parseDotenv(content, func(key string) string {
+		return map[string]string{"HOME": "/home/x"}[key]
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]string{
+		{"FOO", "foo"},
+		{"BAR", "bar $FOO"},
+		{"BAZ", "foo-/home/x\n\"q\""},
+		{"EMPTY", ""},
+		{"MULTI", "a\nb"},
+		{"URL", "http://x#y"},
+		{"TAB", "1"},
+	}, kvs)
+
+	_, err = parseDotenv("FOO", func(string) string { return "" })
+	assert.Error(t, err)
+	_, err = parseDotenv(`FOO="bar`, func(string) string { return "" })
+	assert.Error(t, err)
+	_, err = parseDotenv(`FOO='bar' baz`, func(string) string { return "" })
+	assert.Error(t, err)
+}
+
+func TestEnvFilesWatched(t *testing.T) {
+	root := t.TempDir()
+	// 一个在 root 之外, 一个在被忽略的隐藏目录下且启动时还不存在
+	outside := filepath.Join(t.TempDir(), ".env")
+	hidden := filepath.Join(root, ".config", ".env")
+	assert.NoError(t, os.Mkdir(filepath.Dir(hidden), 0755))
+	assert.NoError(t, os.WriteFile(outside, []byte("FOO=1\n"), 0644))
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithIncludeExts([]string{".go"}),
+		WithDelay(50*time.Millisecond),
+		WithEnvFiles([]string{outside, hidden}),
+		WithRun([]string{`echo "$FOO$BAR" >> out.txt`}),
+	)
+	assert.NoError(t, err)
+	for _, path := range []string{outside, hidden} {
+		assert.Equal(t, WatchDecision{Path: path, Watched: true, Reason: "env file"}, w.CheckIgnore(path))
+	}
+	assert.NoError(t, w.Start(context.Background()))
+	defer w.Stop(context.Background())
+	lines := func() string {
+		bs, _ := os.ReadFile(filepath.Join(root, "out.txt"))
+		return strings.TrimSpace(string(bs))
+	}
+	assert.Eventually(t, func() bool { return lines() == "1" }, 3*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, os.WriteFile(outside, []byte("FOO=2\n"), 0644))
+	assert.Eventually(t, func() bool { return lines() == "1\n2" }, 3*time.Second, 10*time.Millisecond)
+	assert.NoError(t, os.WriteFile(hidden, []byte("BAR=3\n"), 0644))
+	assert.Eventually(t, func() bool { return lines() == "1\n2\n23" }, 3*time.Second, 10*time.Millisecond)
+	// 同目录下的其他文件不被监听
+	assert.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(outside), "other"), nil, 0644))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "1\n2\n23", lines())
+}
@@ -0,0 +1,270 @@
+//go:build linux
+
+package war
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+const fanotifyMask = unix.FAN_CREATE | unix.FAN_DELETE | unix.FAN_MODIFY | unix.FAN_MOVED_FROM | unix.FAN_MOVED_TO | unix.FAN_ONDIR
+
+type (
+	// fanotifyWatcher marks the whole filesystem of each watched path once, events carry the handle of the parent dir
+	// and the name of the child (FAN_REPORT_DFID_NAME). Events of dirs which are not added are dropped in user space.
+	fanotifyWatcher struct {
+		file   *os.File
+		events chan fsnotify.Event
+		errors chan error
+		done   chan struct{}
+		mu     sync.Mutex
+		// dirs 是 fsid+file handle -> 目录, key 的格式和事件中的一致
+		dirs map[string]*fanotifyDir
+		// paths 是被 Add 的路径 -> 它所在的目录的 key
+		paths map[string]string
+		// marked 是已经 mark 过的文件系统的 fsid
+		marked map[unix.Fsid]bool
+	}
+	fanotifyDir struct {
+		// path 是 Add 这个目录时的路径, 为空表示只 Add 了它下面的文件
+		path string
+		// files 是 Add 的文件 name -> Add 时的路径
+		files map[string]string
+	}
+)
+
+func newFanotifyWatcher(root string) (fsWatcher, error) {
+	// 整个文件系统的事件都会进入队列, 因此不限制队列长度 (同样需要 CAP_SYS_ADMIN). 溢出时由 rescan 处理
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_REPORT_DFID_NAME|unix.FAN_UNLIMITED_QUEUE|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE)
+	if err != nil {
+		return nil, fmt.Errorf("fanotify_init error: %w", err)
+	}
+	w := &fanotifyWatcher{
+		// fd 是非阻塞的, os.File 会使用 runtime 的 poller, Close 可以中断 Read
+		file:   os.NewFile(uintptr(fd), "fanotify"),
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+		dirs:   make(map[string]*fanotifyDir),
+		paths:  make(map[string]string),
+		marked: make(map[unix.Fsid]bool),
+	}
+	// 提前检查 root 所在的文件系统是否支持, 这样可以在启动前回退到 inotify
+	if _, err := w.key(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.readLoop()
+	return w, nil
+}
+
+func (w *fanotifyWatcher) Add(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat.IsDir() {
+		key, err := w.key(path)
+		if err != nil {
+			return err
+		}
+		w.dir(key).path = path
+		w.paths[path] = key
+		return nil
+	}
+	// 文件的事件带的是父目录的 handle 和文件名. 如果 path 是 symlink, 事件发生在目标文件上
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	key, err := w.key(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	d := w.dir(key)
+	if d.files == nil {
+		d.files = make(map[string]string)
+	}
+	d.files[filepath.Base(target)] = path
+	w.paths[path] = key
+	return nil
+}
+
+func (w *fanotifyWatcher) Remove(path string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key, ok := w.paths[path]
+	if !ok {
+		return fmt.Errorf("%w: %s", fsnotify.ErrNonExistentWatch, path)
+	}
+	delete(w.paths, path)
+	d := w.dirs[key]
+	if d.path == path {
+		d.path = ""
+	}
+	for name, p := range d.files {
+		if p == path {
+			delete(d.files, name)
+		}
+	}
+	if d.path == "" && len(d.files) == 0 {
+		delete(w.dirs, key)
+	}
+	return nil
+}
+
+func (w *fanotifyWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *fanotifyWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *fanotifyWatcher) Errors() <-chan error          { return w.errors }
+
+func (w *fanotifyWatcher) dir(key string) *fanotifyDir {
+	d, ok := w.dirs[key]
+	if !ok {
+		d = &fanotifyDir{}
+		w.dirs[key] = d
+	}
+	return d
+}
+
+// key returns fsid+file handle of dir, the filesystem of dir is marked if it has not been marked.
+func (w *fanotifyWatcher) key(dir string) (string, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return "", err
+	}
+	if !w.marked[st.Fsid] {
+		if err := unix.FanotifyMark(int(w.file.Fd()), unix.FAN_MARK_ADD|unix.FAN_MARK_FILESYSTEM, fanotifyMask, unix.AT_FDCWD, dir); err != nil {
+			return "", fmt.Errorf("fanotify_mark %s error: %w", dir, err)
+		}
+		w.marked[st.Fsid] = true
+	}
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, dir, unix.AT_SYMLINK_FOLLOW)
+	if err != nil {
+		return "", fmt.Errorf("name_to_handle_at %s error: %w", dir, err)
+	}
+	return fanotifyKey(st.Fsid, handle.Type(), handle.Bytes()), nil
+}
+
+func fanotifyKey(fsid unix.Fsid, handleType int32, handle []byte) string {
+	buf := make([]byte, 12, 12+len(handle))
+	binary.NativeEndian.PutUint32(buf[0:], uint32(fsid.Val[0]))
+	binary.NativeEndian.PutUint32(buf[4:], uint32(fsid.Val[1]))
+	binary.NativeEndian.PutUint32(buf[8:], uint32(handleType))
+	return string(append(buf, handle...))
+}
+
+func (w *fanotifyWatcher) readLoop() {
+	defer close(w.events)
+	defer close(w.errors)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.sendError(err)
+			}
+			return
+		}
+		for off := 0; off+int(unsafe.Sizeof(unix.FanotifyEventMetadata{})) <= n; {
+			meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[off]))
+			if meta.Event_len == 0 || off+int(meta.Event_len) > n {
+				break
+			}
+			if meta.Vers != unix.FANOTIFY_METADATA_VERSION {
+				w.sendError(fmt.Errorf("unsupported fanotify metadata version %d", meta.Vers))
+				return
+			}
+			if meta.Mask&unix.FAN_Q_OVERFLOW != 0 {
+				w.sendError(fsnotify.ErrEventOverflow)
+			} else if name, ok := w.parse(buf[off+int(meta.Metadata_len) : off+int(meta.Event_len)]); ok {
+				if !w.sendEvent(fsnotify.Event{Name: name, Op: fanotifyOp(meta.Mask)}) {
+					return
+				}
+			}
+			off += int(meta.Event_len)
+		}
+	}
+}
+
+// parse returns the path of the event from the DFID_NAME info record, false if its dir is not watched.
+func (w *fanotifyWatcher) parse(info []byte) (string, bool) {
+	// struct fanotify_event_info_fid: hdr (type, pad, len) + fsid + struct file_handle + name
+	if len(info) < 20 || info[0] != unix.FAN_EVENT_INFO_TYPE_DFID_NAME {
+		return "", false
+	}
+	fsid := unix.Fsid{Val: [2]int32{int32(binary.NativeEndian.Uint32(info[4:])), int32(binary.NativeEndian.Uint32(info[8:]))}}
+	size := int(binary.NativeEndian.Uint32(info[12:]))
+	handleType := int32(binary.NativeEndian.Uint32(info[16:]))
+	if len(info) < 20+size {
+		return "", false
+	}
+	name := info[20+size:]
+	for i, b := range name {
+		if b == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d, ok := w.dirs[fanotifyKey(fsid, handleType, info[20:20+size])]
+	if !ok || len(name) == 0 || string(name) == "." {
+		return "", false
+	}
+	if d.path != "" {
+		return filepath.Join(d.path, string(name)), true
+	}
+	path, ok := d.files[string(name)]
+	return path, ok
+}
+
+func fanotifyOp(mask uint64) fsnotify.Op {
+	var op fsnotify.Op
+	if mask&(unix.FAN_CREATE|unix.FAN_MOVED_TO) != 0 {
+		op |= fsnotify.Create
+	}
+	if mask&unix.FAN_MODIFY != 0 {
+		op |= fsnotify.Write
+	}
+	if mask&unix.FAN_DELETE != 0 {
+		op |= fsnotify.Remove
+	}
+	if mask&unix.FAN_MOVED_FROM != 0 {
+		op |= fsnotify.Rename
+	}
+	return op
+}
+
+func (w *fanotifyWatcher) sendEvent(e fsnotify.Event) bool {
+	select {
+	case w.events <- e:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *fanotifyWatcher) sendError(err error) {
+	select {
+	case w.errors <- err:
+	case <-w.done:
+	}
+}
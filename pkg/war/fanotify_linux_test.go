@@ -0,0 +1,38 @@
+//go:build linux
+
+package war
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFanotifyWatcher(t *testing.T) {
+	root := t.TempDir()
+	fw, err := newFanotifyWatcher(root)
+	if err != nil {
+		// 需要 CAP_SYS_ADMIN 和 linux >= 5.9
+		t.Skipf("fanotify can not be used: %+v", err)
+	}
+	defer fw.Close()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "a"), 0755))
+	assert.NoError(t, fw.Add(filepath.Join(root, "a")))
+
+	// 没有 Add 的目录的事件被丢弃
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "x.go"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a", "b.go"), nil, 0644))
+	select {
+	case e := <-fw.Events():
+		assert.Equal(t, filepath.Join(root, "a", "b.go"), e.Name)
+		assert.True(t, e.Has(fsnotify.Create))
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+
+	assert.NoError(t, fw.Remove(filepath.Join(root, "a")))
+	assert.Error(t, fw.Remove(filepath.Join(root, "a")))
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package war
+
+import "errors"
+
+func newFanotifyWatcher(root string) (fsWatcher, error) {
+	return nil, errors.New("fanotify is only supported on linux")
+}
@@ -0,0 +1,54 @@
+package war
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ValidateGlob checks the syntax of a doublestar glob such as "internal/**/*.go".
+func ValidateGlob(pattern string) error {
+	for _, seg := range strings.Split(pattern, "/") {
+		if seg == "**" {
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return fmt.Errorf("bad glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the slash separated path matches the doublestar glob pattern.
+// "**" matches zero or more path segments, other segments use the syntax of path.Match.
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(patterns []string, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			// 连续的 ** 等价于一个
+			for len(patterns) > 0 && patterns[0] == "**" {
+				patterns = patterns[1:]
+			}
+			if len(patterns) == 0 {
+				return true
+			}
+			for i := range names {
+				if matchSegments(patterns, names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(patterns[0], names[0]); !ok {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
@@ -0,0 +1,32 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "a/b/main.go", true},
+		{"**/*.go", "a/b/main.gox", false},
+		{"go.mod", "go.mod", true},
+		{"go.mod", "a/go.mod", false},
+		{"internal/**", "internal/a/b.txt", true},
+		{"internal/**", "pkg/internal/a.txt", false},
+		{"internal/**/*.tmpl.html", "internal/x.tmpl.html", true},
+		{"internal/**/*.tmpl.html", "internal/a/b/x.tmpl.html", true},
+		{"**/Dockerfile", "deploy/Dockerfile", true},
+		{"a/**/**/b", "a/b", true},
+		{"a/*/b", "a/x/y/b", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchGlob(c.pattern, c.name), "%s %s", c.pattern, c.name)
+	}
+	assert.Error(t, ValidateGlob("a/[b"))
+	assert.NoError(t, ValidateGlob("**/*.go"))
+}
@@ -0,0 +1,192 @@
+package war
+
+import (
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/samber/lo"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// IgnoreRule is a line of an ignore file.
+	IgnoreRule struct {
+		// Source is the path of the ignore file.
+		Source string
+		// LineNo is 1-based.
+		LineNo int
+		Line   string
+		negate bool
+		gi     *gitignore.GitIgnore
+	}
+	// ignoreSource is the rules of an ignore file, which are matched against paths relative to dir.
+	ignoreSource struct {
+		dir   string
+		rules []*IgnoreRule
+	}
+	// GitIgnorer implements the ignore semantics of git: nested .gitignore files scoped to their directory,
+	// .git/info/exclude and the global core.excludesFile.
+	// The last matching rule decides, and rules of deeper .gitignore files take precedence.
+	GitIgnorer struct {
+		// global 是 core.excludesFile 和 .git/info/exclude, 以及 root 之上的 .gitignore, 优先级从低到高
+		global []*ignoreSource
+		// nested 是 dir -> dir/.gitignore
+		nested map[string]*ignoreSource
+	}
+)
+
+// NewGitIgnorer creates a GitIgnorer for root, it loads the global sources and the .gitignore files from the
+// git top dir down to root. The .gitignore files under root are loaded by LoadDir.
+func NewGitIgnorer(root string) *GitIgnorer {
+	g := &GitIgnorer{nested: make(map[string]*ignoreSource)}
+	top := gitTopDir(root)
+	if top == "" {
+		top = root
+	}
+	if path := globalExcludesFile(top); path != "" {
+		g.global = append(g.global, loadIgnoreSource(path, top))
+	}
+	g.global = append(g.global, loadIgnoreSource(filepath.Join(top, ".git", "info", "exclude"), top))
+	// root 之上 (到 git top 为止) 的 .gitignore 对 root 下的文件同样生效
+	var parents []string
+	for dir := filepath.Dir(root); strings.HasPrefix(dir, top) && dir != root; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+		if dir == top {
+			break
+		}
+	}
+	for i := len(parents) - 1; i >= 0; i-- {
+		g.global = append(g.global, loadIgnoreSource(filepath.Join(parents[i], ".gitignore"), parents[i]))
+	}
+	g.global = lo.Filter(g.global, func(s *ignoreSource, _ int) bool { return len(s.rules) > 0 })
+	return g
+}
+
+// LoadDir (re)loads dir/.gitignore, it returns true if the rules changed.
+func (g *GitIgnorer) LoadDir(dir string) bool {
+	source := loadIgnoreSource(filepath.Join(dir, ".gitignore"), dir)
+	old, ok := g.nested[dir]
+	if len(source.rules) == 0 {
+		delete(g.nested, dir)
+		return ok
+	}
+	g.nested[dir] = source
+	return !ok || !sameRules(old.rules, source.rules)
+}
+
+// Match returns whether path is ignored, and the rule which decided it (nil if no rule matches).
+func (g *GitIgnorer) Match(path string, isDir bool) (bool, *IgnoreRule) {
+	var nested []*ignoreSource
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if s, ok := g.nested[dir]; ok {
+			nested = append(nested, s)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	// 越深的 .gitignore 优先级越高, 因此逆序放在最后
+	sources := append(append([]*ignoreSource{}, g.global...), lo.Reverse(nested)...)
+	var decided *IgnoreRule
+	for _, s := range sources {
+		if rule := s.match(path, isDir); rule != nil {
+			decided = rule
+		}
+	}
+	return decided != nil && !decided.negate, decided
+}
+
+// match returns the last rule of s matching path.
+func (s *ignoreSource) match(path string, isDir bool) *IgnoreRule {
+	rel, err := filepath.Rel(s.dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	rel = filepath.ToSlash(rel)
+	var decided *IgnoreRule
+	for _, rule := range s.rules {
+		if rule.gi.MatchesPath(rel) || (isDir && rule.gi.MatchesPath(rel+"/")) {
+			decided = rule
+		}
+	}
+	return decided
+}
+
+func loadIgnoreSource(path string, dir string) *ignoreSource {
+	s := &ignoreSource{dir: dir}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return s
+	}
+	s.rules = compileIgnoreRules(path, strings.Split(string(bs), "\n"))
+	return s
+}
+
+// compileIgnoreRules compiles each line to a rule, so that negation and the deciding line can be tracked.
+func compileIgnoreRules(source string, lines []string) []*IgnoreRule {
+	var rules []*IgnoreRule
+	for i, line := range lines {
+		pattern := strings.TrimSpace(strings.TrimRight(line, "\r"))
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		rule := &IgnoreRule{Source: source, LineNo: i + 1, Line: line}
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		// 开头或中间有 / 的规则是相对于 .gitignore 所在目录的
+		if trimmed := strings.TrimSuffix(pattern, "/"); strings.Contains(trimmed, "/") &&
+			!strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**/") {
+			pattern = "/" + pattern
+		}
+		rule.gi = gitignore.CompileIgnoreLines(pattern)
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func sameRules(a, b []*IgnoreRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].LineNo != b[i].LineNo || a[i].Line != b[i].Line {
+			return false
+		}
+	}
+	return true
+}
+
+// gitTopDir returns the dir containing .git, searching upwards from dir.
+func gitTopDir(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// globalExcludesFile returns the path of core.excludesFile, which defaults to $XDG_CONFIG_HOME/git/ignore.
+func globalExcludesFile(dir string) string {
+	cmd := exec.Command("git", "config", "--path", "--get", "core.excludesFile")
+	cmd.Dir = dir
+	if out, err := cmd.Output(); err == nil {
+		if path := strings.TrimSpace(string(out)); path != "" {
+			return path
+		}
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
@@ -3,6 +3,8 @@ package war
 import (
 	gitignore "github.com/sabhiram/go-gitignore"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -24,3 +26,39 @@ func TestIgnore(t *testing.T) {
 	assert.False(t, gi.MatchesPath("bbb/t2"))
 	assert.True(t, gi.MatchesPath("bbb/t2/"))
 }
+
+func TestGitIgnorer(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, ".git", "info"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, ".git", "info", "exclude"), []byte("*.tmp\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.log\nbuild/\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a", ".gitignore"), []byte("!keep.log\nb/gen\n"), 0644))
+
+	g := NewGitIgnorer(root)
+	assert.False(t, g.LoadDir(filepath.Join(root, "a", "b")))
+	assert.True(t, g.LoadDir(root))
+	assert.True(t, g.LoadDir(filepath.Join(root, "a")))
+	assert.False(t, g.LoadDir(filepath.Join(root, "a")))
+
+	ignored, rule := g.Match(filepath.Join(root, "x.tmp"), false)
+	assert.True(t, ignored)
+	assert.Equal(t, filepath.Join(root, ".git", "info", "exclude"), rule.Source)
+
+	ignored, _ = g.Match(filepath.Join(root, "a", "x.log"), false)
+	assert.True(t, ignored)
+	// 更深的 .gitignore 中的否定规则优先
+	ignored, rule = g.Match(filepath.Join(root, "a", "keep.log"), false)
+	assert.False(t, ignored)
+	assert.Equal(t, 1, rule.LineNo)
+
+	ignored, _ = g.Match(filepath.Join(root, "a", "b", "gen"), true)
+	assert.True(t, ignored)
+	ignored, _ = g.Match(filepath.Join(root, "b", "gen"), true)
+	assert.False(t, ignored)
+
+	ignored, _ = g.Match(filepath.Join(root, "a", "build"), true)
+	assert.True(t, ignored)
+	ignored, _ = g.Match(filepath.Join(root, "a", "build"), false)
+	assert.False(t, ignored)
+}
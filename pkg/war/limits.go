@@ -0,0 +1,79 @@
+package war
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errTimeout = errors.New("timeout")
+
+// NoTimeout as Limits.Timeout opts a command out of the default timeout.
+const NoTimeout time.Duration = -1
+
+type (
+	// Limits are the resource limits of a command. Zero value means no limit.
+	Limits struct {
+		// Memory in bytes. It is applied via memory.max of the run cgroup if reap mode is cgroup, otherwise via RLIMIT_AS.
+		Memory int64
+		// CPUTime is applied via RLIMIT_CPU.
+		CPUTime time.Duration
+		// OpenFiles is applied via RLIMIT_NOFILE.
+		OpenFiles uint64
+		// Timeout is the wall-clock timeout of the command. Zero means the default timeout (WithTimeout),
+		// NoTimeout means no timeout even if the default timeout is set.
+		Timeout time.Duration
+	}
+	// limitError is returned when a run is killed by a resource limit.
+	limitError struct {
+		reason string
+	}
+)
+
+func (e *limitError) Error() string {
+	return "resource limit exceeded: " + e.reason
+}
+
+// commandTimeout returns the timeout of command, zero means no timeout.
+func (w *WatchAndRun) commandTimeout(command Command) time.Duration {
+	switch {
+	case command.Limits.Timeout == 0:
+		return w.options.timeout
+	case command.Limits.Timeout < 0:
+		return 0
+	default:
+		return command.Limits.Timeout
+	}
+}
+
+func (l Limits) hasRlimits() bool {
+	return l.Memory > 0 || l.CPUTime > 0 || l.OpenFiles > 0
+}
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+}
+
+// ParseSize parses a size like "512MB" or "1g", units are 1024 based.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(n * float64(unit)), nil
+}
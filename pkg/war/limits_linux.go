@@ -0,0 +1,145 @@
+//go:build linux
+
+package war
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// rlimitsEnv makes war apply the rlimits in it, then exec os.Args[1:], see startWithLimits.
+const rlimitsEnv = "_WAR_EXEC_RLIMITS"
+
+func init() {
+	spec, ok := os.LookupEnv(rlimitsEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(rlimitsEnv)
+	if err := execWithRlimits(spec, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "war: %v\n", err)
+		os.Exit(127)
+	}
+}
+
+// execWithRlimits applies rlimits in the form of "resource:cur:max,...", then execs args, args[0] is the path.
+func execWithRlimits(spec string, args []string) error {
+	for _, item := range strings.Split(spec, ",") {
+		var resource int
+		var rlimit syscall.Rlimit
+		if _, err := fmt.Sscanf(item, "%d:%d:%d", &resource, &rlimit.Cur, &rlimit.Max); err != nil {
+			return fmt.Errorf("invalid rlimit %q: %v", item, err)
+		}
+		// 必须用 syscall.Setrlimit, 否则 exec 时 go 会恢复启动时的 RLIMIT_NOFILE
+		if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+			return fmt.Errorf("setrlimit %q error: %v", item, err)
+		}
+	}
+	if len(args) < 2 {
+		return errors.New("no command to exec")
+	}
+	return syscall.Exec(args[0], args[1:], os.Environ())
+}
+
+// startWithLimits starts execCmd with the resource limits applied before it executes the command,
+// so that the command and all its descendants are limited.
+// The rlimits are applied by a re-exec of war, it applies them to itself and then execs the command.
+func (w *WatchAndRun) startWithLimits(hint string, execCmd *exec.Cmd, limits Limits) error {
+	var rlimits []string
+	if limits.Memory > 0 && !w.applyMemoryMax(hint, limits) {
+		rlimits = append(rlimits, fmt.Sprintf("%d:%d:%d", unix.RLIMIT_AS, limits.Memory, limits.Memory))
+	}
+	if limits.CPUTime > 0 {
+		// 超过软限制时收到 SIGXCPU, 再多 1s 收到 SIGKILL
+		seconds := uint64((limits.CPUTime + time.Second - 1) / time.Second)
+		rlimits = append(rlimits, fmt.Sprintf("%d:%d:%d", unix.RLIMIT_CPU, seconds, seconds+1))
+	}
+	if limits.OpenFiles > 0 {
+		rlimits = append(rlimits, fmt.Sprintf("%d:%d:%d", unix.RLIMIT_NOFILE, limits.OpenFiles, limits.OpenFiles))
+	}
+	if len(rlimits) == 0 {
+		return execCmd.Start()
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("apply resource limits error: %v", err)
+	}
+	if execCmd.Env == nil {
+		execCmd.Env = os.Environ()
+	}
+	execCmd.Env = append(execCmd.Env, rlimitsEnv+"="+strings.Join(rlimits, ","))
+	execCmd.Args = append([]string{self, execCmd.Path}, execCmd.Args...)
+	execCmd.Path = self
+	return execCmd.Start()
+}
+
+// applyMemoryMax applies the memory limit via memory.max of the run cgroup, it returns false if it is not available.
+func (w *WatchAndRun) applyMemoryMax(hint string, limits Limits) bool {
+	dir := w.runCgroupDir()
+	if dir == "" {
+		w.warnRlimitAS(hint, limits, "reap is not cgroup")
+		return false
+	}
+	if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(limits.Memory, 10)), 0644); err != nil {
+		// war 所在的 cgroup 里有进程, 因此通常无法给子 cgroup 开启 memory controller (no internal process 规则)
+		w.warnRlimitAS(hint, limits, fmt.Sprintf("memory.max is not available: %v", err))
+		return false
+	}
+	return true
+}
+
+func (w *WatchAndRun) warnRlimitAS(hint string, limits Limits, reason string) {
+	if w.rlimitASWarned {
+		return
+	}
+	w.rlimitASWarned = true
+	w.logWarn("%s: memory limit %d bytes is applied via RLIMIT_AS (virtual memory, it may break the JVM and node), %s",
+		hint, limits.Memory, reason)
+}
+
+// memoryMaxApplied reports whether the memory limit is applied via memory.max of the run cgroup.
+func (w *WatchAndRun) memoryMaxApplied(limits Limits) bool {
+	dir := w.runCgroupDir()
+	if dir == "" {
+		return false
+	}
+	bs, err := os.ReadFile(filepath.Join(dir, "memory.max"))
+	return err == nil && strings.TrimSpace(string(bs)) == strconv.FormatInt(limits.Memory, 10)
+}
+
+// limitExceeded returns a limitError if the process exited because of a resource limit.
+// Exceeding RLIMIT_AS is not reported, the kernel does not record it.
+func (w *WatchAndRun) limitExceeded(err error, limits Limits) error {
+	if limits.Memory > 0 && w.memoryMaxApplied(limits) {
+		bs, _ := os.ReadFile(filepath.Join(w.runCgroupDir(), "memory.events"))
+		for _, line := range strings.Split(string(bs), "\n") {
+			if n, ok := strings.CutPrefix(line, "oom_kill "); ok && n != "0" {
+				return &limitError{reason: fmt.Sprintf("memory %d bytes", limits.Memory)}
+			}
+		}
+	}
+	var exitErr *exec.ExitError
+	if limits.CPUTime > 0 && errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() && (ws.Signal() == syscall.SIGXCPU || ws.Signal() == syscall.SIGKILL) {
+			if ws.Signal() == syscall.SIGXCPU || exitErr.ProcessState.UserTime()+exitErr.ProcessState.SystemTime() >= limits.CPUTime {
+				return &limitError{reason: fmt.Sprintf("cpu time %s", limits.CPUTime)}
+			}
+		}
+	}
+	return nil
+}
+
+func (w *WatchAndRun) runCgroupDir() string {
+	if r, ok := w.reaper.(*cgroupReaper); ok {
+		return r.current
+	}
+	return ""
+}
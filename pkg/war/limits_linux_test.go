@@ -0,0 +1,26 @@
+//go:build linux
+
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestStartWithLimits(t *testing.T) {
+	w := &WatchAndRun{}
+	// 管道中的进程是 shell fork 出来的, 它们也必须受限制
+	execCmd := exec.Command("sh", "-c", "ulimit -n | cat; ulimit -v")
+	var out strings.Builder
+	execCmd.Stdout = &out
+	assert.NoError(t, w.startWithLimits("test", execCmd, Limits{OpenFiles: 77, Memory: 1 << 30}))
+	assert.NoError(t, execCmd.Wait())
+	assert.Equal(t, "77\n1048576", strings.TrimSpace(out.String()))
+	// 没有 cgroup 时使用 RLIMIT_AS, 只提示一次
+	assert.True(t, w.rlimitASWarned)
+
+	execCmd = exec.Command("no-such-command-of-war")
+	assert.Error(t, w.startWithLimits("test", execCmd, Limits{OpenFiles: 77}))
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package war
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func (w *WatchAndRun) startWithLimits(hint string, execCmd *exec.Cmd, limits Limits) error {
+	if limits.hasRlimits() {
+		return errors.New("resource limits are only supported on linux")
+	}
+	return execCmd.Start()
+}
+
+func (w *WatchAndRun) limitExceeded(err error, limits Limits) error {
+	return nil
+}
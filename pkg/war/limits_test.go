@@ -0,0 +1,32 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"1024":   1024,
+		"512MB":  512 << 20,
+		"1g":     1 << 30,
+		"1.5KiB": 1536,
+		"2 k":    2048,
+	} {
+		size, err := ParseSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+	_, err := ParseSize("12XB")
+	assert.Error(t, err)
+	_, err = ParseSize("MB")
+	assert.Error(t, err)
+}
+
+func TestCommandTimeout(t *testing.T) {
+	w := &WatchAndRun{options: options{timeout: time.Minute}}
+	assert.Equal(t, time.Minute, w.commandTimeout(Command{}))
+	assert.Equal(t, time.Second, w.commandTimeout(Command{Limits: Limits{Timeout: time.Second}}))
+	assert.Equal(t, time.Duration(0), w.commandTimeout(Command{Limits: Limits{Timeout: NoTimeout}}))
+}
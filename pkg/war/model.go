@@ -1,25 +1,148 @@
 package war
 
-import "time"
+import (
+	gitignore "github.com/sabhiram/go-gitignore"
+	"syscall"
+	"time"
+)
 
 type (
 	Duration time.Duration
 	Config   struct {
 		Root string
+		// Roots are watched like Root: string, []string or []WatchPathConfig (mixed with string)
+		Roots any `toml:"roots"`
+		// ExtraWatch are files or dirs watched with their own ignore rules only, the same types as Roots
+		ExtraWatch any `toml:"extra_watch"`
 		// Build string or []string
 		Build any
-		// Run string or []string
+		// Run string, []string or []RunConfig (mixed with string)
 		Run         any
 		IncludeExts []string `toml:"include_exts"`
+		// Include doublestar globs relative to root, a file is included if it matches IncludeExts or Include
+		Include     []string `toml:"include"`
 		IgnoreRules []string `toml:"ignore_rules"`
 		IgnoreFile  string   `toml:"ignore_file"`
+		// GitIgnore respects nested .gitignore files, .git/info/exclude and core.excludesFile
+		GitIgnore bool `toml:"gitignore"`
+		// WatchHidden watches hidden dirs except .git, .hg and .svn
+		WatchHidden bool `toml:"watch_hidden"`
+		// HiddenDirs are hidden dir names or root relative paths watched even if WatchHidden is false
+		HiddenDirs []string `toml:"hidden_dirs"`
+		// TempFiles are file name patterns extending the built-in editor temp file catalog
+		TempFiles []string `toml:"temp_files"`
+		// FollowSymlinks watches the targets of symlinks
+		FollowSymlinks bool `toml:"follow_symlinks"`
+		// WatchLimitFallback fail, poll or degraded, when dirs can not be watched because of the inotify limits
+		WatchLimitFallback string    `toml:"watch_limit_fallback"`
+		PollInterval       *Duration `toml:"poll_interval"`
+		// Backend inotify or fanotify
+		Backend string `toml:"backend"`
+		Delay   *Duration
+		// MaxWait guarantees a run at most MaxWait after the first pending change
+		MaxWait     *Duration `toml:"max_wait"`
+		Leading     bool      `toml:"leading"`
+		MinInterval *Duration `toml:"min_interval"`
+		// Timing rules override the global delay, max_wait, leading and min_interval for matching changes
+		Timing     []TimingConfig `toml:"timing"`
+		CancelLast *bool          `toml:"cancel_last"`
+		// QueueMode restart, queue or drop, it overrides CancelLast
+		QueueMode    string            `toml:"queue_mode"`
+		TermTimeout  *Duration         `toml:"term_timeout"`
+		Env          map[string]string `toml:"env"`
+		EnvFiles     []string          `toml:"env_files"`
+		Pty          bool              `toml:"pty"`
+		ForwardStdin bool              `toml:"forward_stdin"`
+		Stream       bool              `toml:"stream"`
+		// StopSignals e.g. ["SIGINT:2s", "SIGTERM:5s", "SIGKILL"]
+		StopSignals []string `toml:"stop_signals"`
+		StopGroup   *bool    `toml:"stop_group"`
+		// Reap "subreaper" or "cgroup", linux only
+		Reap        string    `toml:"reap"`
+		PortTimeout *Duration `toml:"port_timeout"`
+		// Timeout is the default timeout of run commands
+		Timeout           *Duration `toml:"timeout"`
+		ContinueOnTimeout bool      `toml:"continue_on_timeout"`
+		// Shell sh, bash, zsh, fish or none
+		Shell string `toml:"shell"`
+	}
+	// TimingConfig is a timing rule, unset fields fall back to the global settings.
+	TimingConfig struct {
+		// Match is a list of gitignore style rules matched against root relative paths
+		Match       []string
 		Delay       *Duration
-		CancelLast  *bool             `toml:"cancel_last"`
-		TermTimeout *Duration         `toml:"term_timeout"`
-		Env         map[string]string `toml:"env"`
+		MaxWait     *Duration `toml:"max_wait"`
+		Leading     *bool     `toml:"leading"`
+		MinInterval *Duration `toml:"min_interval"`
+	}
+	// WatchPathConfig is a roots or extra_watch entry in the form of a table.
+	WatchPathConfig struct {
+		// Path is relative to root if it is not absolute
+		Path string
+		// Ignore is a list of gitignore style rules matched against paths relative to Path
+		Ignore []string
+	}
+	// RunConfig is a run entry in the form of a table.
+	RunConfig struct {
+		Cmd string
+		// ReloadSignal is sent to the process group instead of restarting it, e.g. "SIGHUP"
+		ReloadSignal string `toml:"reload_signal"`
+		// ReloadOn is a list of gitignore style rules, only changes matching these rules are reloaded.
+		// An empty value indicates all changes are reloaded.
+		ReloadOn []string `toml:"reload_on"`
+		// StopCmd runs instead of sending stop signals, e.g. "docker stop foo"
+		StopCmd string `toml:"stop_cmd"`
+		// Ports must be free before the command starts
+		Ports []int `toml:"ports"`
+		// Memory e.g. "512MB"
+		Memory    string    `toml:"memory"`
+		CPUTime   *Duration `toml:"cpu_time"`
+		OpenFiles uint64    `toml:"open_files"`
+		Timeout   *Duration `toml:"timeout"`
+		// ContinueOnTimeout overrides the global continue_on_timeout
+		ContinueOnTimeout *bool `toml:"continue_on_timeout"`
+		// Shell overrides the global shell
+		Shell string `toml:"shell"`
+		// Dir relative to root
+		Dir string
+		Env map[string]string
+		// Stdin "null", "inherit" or "file:/path/to/file"
+		Stdin string
+	}
+	// Command is a step of run.
+	Command struct {
+		Cmd string
+		// If ReloadSignal is not zero, it is sent to the process group when files matching ReloadOn change,
+		// instead of restarting the process. Only the last command can have it.
+		ReloadSignal syscall.Signal
+		// ReloadOn matches root relative paths. If ReloadOn is nil, all changes are reloaded.
+		ReloadOn *gitignore.GitIgnore
+		// If StopCmd is not empty, it runs instead of sending stop signals to cancel the process.
+		// The pid of the process is visible to it as WAR_PID.
+		StopCmd string
+		// Ports must be free before the process starts, war waits for them at most portTimeout.
+		Ports  []int
+		Limits Limits
+		// If ContinueOnTimeout is true, the next command runs after the command is stopped because of timeout.
+		ContinueOnTimeout bool
+		// Shell overrides the global shell, see WithShell.
+		Shell string
+		// Dir is the working directory of the command, relative to root. It defaults to root.
+		Dir string
+		// Env is merged with the global env, and takes precedence.
+		Env map[string]string
+		// Stdin is one of StdinNull (default), StdinInherit and "file:/path/to/file" (relative to Dir).
+		// It is ignored in stream mode.
+		Stdin string
 	}
 	watchedInfo struct {
 		file bool
+		// symlink 表示这是一个指向文件的 symlink, 它被单独监听
+		symlink bool
+		// id 是目录的 device+inode, 仅在 follow symlinks 时设置
+		id fileID
+		// ino 是开始监听时的 inode, 用于将 rename 和 create 事件配对, 0 表示未知
+		ino uint64
 	}
 	cancel struct {
 		done chan<- struct{}
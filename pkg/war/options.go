@@ -6,18 +6,51 @@ import (
 	"time"
 )
 
+const (
+	// QueueRestart cancels the current run and runs again with all accumulated changes.
+	QueueRestart = "restart"
+	// QueueQueue waits for the current run to finish, then runs once more with all accumulated changes.
+	QueueQueue = "queue"
+	// QueueDrop ignores changes during a run. The final state of the tree may not be built.
+	QueueDrop = "drop"
+)
+
 type (
 	options struct {
-		root        string
-		cfgDir      string
-		run         []string
-		includeExts map[string]struct{}
-		ignore      *gitignore.GitIgnore
-		cancelLast  bool
-		delay       time.Duration
-		termTimeout time.Duration
-		env         map[string]string
-		logLevel    int
+		root               string
+		roots              []WatchPath
+		extraWatch         []WatchPath
+		cfgDir             string
+		run                []Command
+		includeExts        map[string]struct{}
+		include            []string
+		ignore             *gitignore.GitIgnore
+		ignoreRules        []*IgnoreRule
+		cancelLast         bool
+		timing             Timing
+		timingRules        []TimingRule
+		termTimeout        time.Duration
+		env                map[string]string
+		logLevel           int
+		stream             bool
+		stopSteps          []StopStep
+		stopGroup          bool
+		reap               string
+		portTimeout        time.Duration
+		timeout            time.Duration
+		shell              string
+		envFiles           []string
+		pty                bool
+		forwardStdin       bool
+		queueMode          string
+		gitIgnore          bool
+		watchHidden        bool
+		hiddenDirs         []string
+		tempFiles          []string
+		followSymlinks     bool
+		watchLimitFallback string
+		pollInterval       time.Duration
+		backend            string
 	}
 	Option func(*options)
 )
@@ -28,6 +61,22 @@ func WithRoot(root string) Option {
 	}
 }
 
+// WithRoots watches more dirs (or files) like root, include_exts, include and ignore rules apply to them too.
+// Run commands still execute in root.
+func WithRoots(roots []WatchPath) Option {
+	return func(o *options) {
+		o.roots = roots
+	}
+}
+
+// WithExtraWatch watches files or dirs such as config files outside root.
+// Only their own ignore rules apply to them.
+func WithExtraWatch(paths []WatchPath) Option {
+	return func(o *options) {
+		o.extraWatch = paths
+	}
+}
+
 func WithCfgDir(cfgDir string) Option {
 	return func(o *options) {
 		o.cfgDir = cfgDir
@@ -36,7 +85,15 @@ func WithCfgDir(cfgDir string) Option {
 
 func WithRun(run []string) Option {
 	return func(o *options) {
-		o.run = run
+		o.run = lo.Map(run, func(cmd string, _ int) Command {
+			return Command{Cmd: cmd}
+		})
+	}
+}
+
+func WithCommands(commands []Command) Option {
+	return func(o *options) {
+		o.run = commands
 	}
 }
 
@@ -62,7 +119,35 @@ func WithCancelLast(b bool) Option {
 
 func WithDelay(delay time.Duration) Option {
 	return func(o *options) {
-		o.delay = delay
+		o.timing.Delay = delay
+	}
+}
+
+// WithMaxWait guarantees a run at most maxWait after the first pending change, even if files keep changing.
+func WithMaxWait(maxWait time.Duration) Option {
+	return func(o *options) {
+		o.timing.MaxWait = maxWait
+	}
+}
+
+// WithLeading triggers a run immediately on the first change after a quiet period.
+func WithLeading(b bool) Option {
+	return func(o *options) {
+		o.timing.Leading = b
+	}
+}
+
+// WithMinInterval sets the minimum interval between the starts of two runs.
+func WithMinInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.timing.MinInterval = interval
+	}
+}
+
+// WithTimingRules sets the timing rules, the first rule matching a changed path overrides the global timing.
+func WithTimingRules(rules []TimingRule) Option {
+	return func(o *options) {
+		o.timingRules = rules
 	}
 }
 
@@ -83,3 +168,162 @@ func WithLogLevel(logLevel int) Option {
 		o.logLevel = logLevel
 	}
 }
+
+// WithStream keeps the last run command alive and writes file changes to its stdin as newline-delimited json,
+// instead of restarting it.
+func WithStream(stream bool) Option {
+	return func(o *options) {
+		o.stream = stream
+	}
+}
+
+// WithStopSteps sets the stop sequence used to cancel the run, it overrides termTimeout.
+func WithStopSteps(steps []StopStep) Option {
+	return func(o *options) {
+		o.stopSteps = steps
+	}
+}
+
+// WithStopGroup sets whether the stop signals are sent to the whole process group or only to the leader.
+func WithStopGroup(b bool) Option {
+	return func(o *options) {
+		o.stopGroup = b
+	}
+}
+
+// WithReap sets how to clean up the descendants which escaped the run process group, see ReapSubreaper and ReapCgroup.
+// It is only supported on linux.
+func WithReap(mode string) Option {
+	return func(o *options) {
+		o.reap = mode
+	}
+}
+
+// WithPortTimeout sets how long to wait for the ports of a command to be free before starting it.
+func WithPortTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.portTimeout = timeout
+	}
+}
+
+// WithTimeout sets the default timeout of commands, it is used when Command.Limits.Timeout is zero.
+// A command opts out of it with NoTimeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithShell sets the shell used to run commands: sh, bash, zsh, fish or none.
+// If shell is "none", commands are split into argv and executed directly.
+func WithShell(shell string) Option {
+	return func(o *options) {
+		o.shell = shell
+	}
+}
+
+// WithEnvFiles sets the .env files loaded before env, later files take precedence. Relative paths are relative to root.
+func WithEnvFiles(paths []string) Option {
+	return func(o *options) {
+		o.envFiles = paths
+	}
+}
+
+// WithPty runs each command under a pseudo-terminal, so that tools keep their colors and progress bars.
+// It is only supported on linux.
+func WithPty(b bool) Option {
+	return func(o *options) {
+		o.pty = b
+	}
+}
+
+// WithForwardStdin forwards the stdin of war to the currently running command whose stdin is not set.
+func WithForwardStdin(b bool) Option {
+	return func(o *options) {
+		o.forwardStdin = b
+	}
+}
+
+// WithFollowSymlinks watches the targets of symlinks under the symlinked paths.
+// A dir reachable from more than one path (such as a symlink loop) is watched only once.
+func WithFollowSymlinks(b bool) Option {
+	return func(o *options) {
+		o.followSymlinks = b
+	}
+}
+
+// WithWatchLimitFallback decides what happens to the dirs which can not be watched because of the inotify limits:
+// WatchLimitFail, WatchLimitPoll or WatchLimitDegraded (default).
+func WithWatchLimitFallback(fallback string) Option {
+	return func(o *options) {
+		o.watchLimitFallback = fallback
+	}
+}
+
+// WithPollInterval is the interval of polling the dirs which can not be watched, it defaults to 1s.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = d
+	}
+}
+
+// WithBackend selects how dirs are watched: BackendInotify (default) or BackendFanotify.
+func WithBackend(backend string) Option {
+	return func(o *options) {
+		o.backend = backend
+	}
+}
+
+// WithInclude only watches the files matching the doublestar globs (or include exts), such as "**/*.go" and "go.mod".
+// The globs are matched against root relative paths.
+func WithInclude(patterns []string) Option {
+	return func(o *options) {
+		o.include = patterns
+	}
+}
+
+// WithWatchHidden watches hidden dirs, except vcs dirs such as .git.
+func WithWatchHidden(b bool) Option {
+	return func(o *options) {
+		o.watchHidden = b
+	}
+}
+
+// WithHiddenDirs watches the hidden dirs even if watch hidden is false.
+// An item is a dir name such as ".github", or a root relative path such as "web/.storybook".
+func WithHiddenDirs(dirs []string) Option {
+	return func(o *options) {
+		o.hiddenDirs = dirs
+	}
+}
+
+// WithTempFiles appends file name patterns (path.Match syntax) to DefaultTempFiles.
+func WithTempFiles(patterns []string) Option {
+	return func(o *options) {
+		o.tempFiles = patterns
+	}
+}
+
+// WithQueueMode sets what happens to changes during a run, see QueueRestart, QueueQueue and QueueDrop.
+// It overrides WithCancelLast.
+func WithQueueMode(mode string) Option {
+	return func(o *options) {
+		o.queueMode = mode
+	}
+}
+
+// WithIgnoreRules appends gitignore style rules matched against root relative paths.
+// source names where the lines come from, such as the path of an ignore file, it is reported by CheckIgnore.
+func WithIgnoreRules(source string, lines []string) Option {
+	return func(o *options) {
+		o.ignoreRules = append(o.ignoreRules, compileIgnoreRules(source, lines)...)
+	}
+}
+
+// WithGitIgnore enables the ignore semantics of git: nested .gitignore files, .git/info/exclude
+// and the global core.excludesFile. .gitignore files are reloaded when they change.
+func WithGitIgnore(b bool) Option {
+	return func(o *options) {
+		o.gitIgnore = b
+	}
+}
@@ -0,0 +1,56 @@
+package war
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"strings"
+	"time"
+)
+
+type (
+	// portHolder is a process listening on a port. pid is 0 if it is unknown.
+	portHolder struct {
+		port int
+		pid  int
+		comm string
+	}
+)
+
+func (h portHolder) String() string {
+	if h.pid == 0 {
+		return fmt.Sprintf("port %d is in use", h.port)
+	}
+	return fmt.Sprintf("port %d is in use by pid=%d (%s)", h.port, h.pid, h.comm)
+}
+
+// waitPortsFree waits at most portTimeout for ports to be free.
+// It returns errCancelled if the run is cancelled while waiting.
+func (w *WatchAndRun) waitPortsFree(hint string, ports []int) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(w.options.portTimeout)
+	logged := false
+	for {
+		holders := portHolders(ports)
+		if len(holders) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			desc := strings.Join(lo.Map(holders, func(h portHolder, _ int) string { return h.String() }), ", ")
+			return fmt.Errorf("ports are still in use after %s: %s", w.options.portTimeout, desc)
+		}
+		if !logged {
+			logged = true
+			for _, h := range holders {
+				w.logWarn("%s: %s, wait for it", hint, h)
+			}
+		}
+		select {
+		case cancelReq := <-w.cancelRunCh:
+			w.cancelRunCh <- cancelReq
+			return errCancelled
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
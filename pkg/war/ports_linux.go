@@ -0,0 +1,88 @@
+//go:build linux
+
+package war
+
+import (
+	"fmt"
+	"github.com/samber/lo"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const tcpListen = "0A"
+
+// portHolders returns the processes listening on ports, by reading /proc/net/tcp and /proc/*/fd.
+func portHolders(ports []int) []portHolder {
+	wanted := make(map[int]struct{}, len(ports))
+	for _, port := range ports {
+		wanted[port] = struct{}{}
+	}
+	// inode -> port
+	inodes := make(map[string]int)
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(bs), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 10 || fields[3] != tcpListen {
+				continue
+			}
+			i := strings.LastIndexByte(fields[1], ':')
+			port, err := strconv.ParseInt(fields[1][i+1:], 16, 32)
+			if err != nil {
+				continue
+			}
+			if _, ok := wanted[int(port)]; ok {
+				inodes[fields[9]] = int(port)
+			}
+		}
+	}
+	if len(inodes) == 0 {
+		return nil
+	}
+	holders := make(map[int]portHolder)
+	entries, _ := os.ReadDir("/proc")
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%s", pid, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if port, ok := inodes[link[len("socket:["):len(link)-1]]; ok {
+				if _, ok := holders[port]; !ok {
+					comm, _ := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+					holders[port] = portHolder{port: port, pid: pid, comm: strings.TrimSpace(string(comm))}
+				}
+			}
+		}
+	}
+	var ret []portHolder
+	for _, port := range inodes {
+		if h, ok := holders[port]; ok {
+			ret = append(ret, h)
+		} else {
+			// 没有权限读取其他用户的 fd
+			ret = append(ret, portHolder{port: port})
+		}
+	}
+	// 同一个端口的 holder 中, pid 已知的排在前面, 去重时保留它
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].port != ret[j].port {
+			return ret[i].port < ret[j].port
+		}
+		return ret[i].pid != 0 && ret[j].pid == 0
+	})
+	return lo.UniqBy(ret, func(h portHolder) int { return h.port })
+}
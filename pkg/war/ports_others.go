@@ -0,0 +1,22 @@
+//go:build !linux
+
+package war
+
+import (
+	"fmt"
+	"net"
+)
+
+// portHolders returns the ports that are in use, by trying to listen on them.
+func portHolders(ports []int) []portHolder {
+	var ret []portHolder
+	for _, port := range ports {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			ret = append(ret, portHolder{port: port})
+			continue
+		}
+		ln.Close()
+	}
+	return ret
+}
@@ -0,0 +1,111 @@
+//go:build linux
+
+package war
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type (
+	// ptyConn is a pseudo-terminal attached to a run process.
+	ptyConn struct {
+		master *os.File
+		slave  *os.File
+		copied chan struct{}
+		winch  chan os.Signal
+	}
+)
+
+// openPty attaches execCmd to a new pseudo-terminal, it must be called before execCmd starts.
+// The process becomes a session leader, so its process group can still be signaled by killProcessGroup.
+func openPty(execCmd *exec.Cmd, stdin bool) (*ptyConn, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlock pty error: %v", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("get pty number error: %v", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	p := &ptyConn{master: master, slave: slave, copied: make(chan struct{})}
+	p.resize()
+	execCmd.Stdout, execCmd.Stderr = slave, slave
+	if stdin {
+		execCmd.Stdin = slave
+	}
+	if execCmd.SysProcAttr == nil {
+		execCmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// setsid 之后进程已经是进程组的 leader 了, 不能再 setpgid
+	execCmd.SysProcAttr.Setpgid = false
+	execCmd.SysProcAttr.Setsid = true
+	execCmd.SysProcAttr.Setctty = true
+	// Ctty 是子进程中的 fd, 1 即 stdout
+	execCmd.SysProcAttr.Ctty = 1
+	return p, nil
+}
+
+// started is called after the process starts.
+func (p *ptyConn) started() {
+	p.slave.Close()
+	go func() {
+		defer close(p.copied)
+		// 子进程退出后, 读 master 会返回 EIO
+		io.Copy(os.Stdout, p.master)
+	}()
+	p.winch = make(chan os.Signal, 1)
+	signal.Notify(p.winch, syscall.SIGWINCH)
+	go func() {
+		for range p.winch {
+			p.resize()
+		}
+	}()
+}
+
+// resize copies the window size of war's terminal to the pty, the kernel sends SIGWINCH to the process.
+func (p *ptyConn) resize() {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return
+	}
+	unix.IoctlSetWinsize(int(p.master.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+// Write writes input to the pty.
+func (p *ptyConn) Write(b []byte) (int, error) {
+	return p.master.Write(b)
+}
+
+// close is called after the process exits, it waits a little for the remaining output.
+func (p *ptyConn) close() {
+	if p.winch != nil {
+		signal.Stop(p.winch)
+		close(p.winch)
+		select {
+		case <-p.copied:
+		case <-time.After(time.Second):
+			// 孙子进程可能还持有 slave
+		}
+	} else {
+		p.slave.Close()
+	}
+	p.master.Close()
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package war
+
+import (
+	"errors"
+	"os/exec"
+)
+
+type ptyConn struct{}
+
+func openPty(*exec.Cmd, bool) (*ptyConn, error) {
+	return nil, errors.New("pty is only supported on linux")
+}
+
+func (p *ptyConn) started() {}
+
+func (p *ptyConn) Write(b []byte) (int, error) {
+	return 0, errors.New("pty is only supported on linux")
+}
+
+func (p *ptyConn) close() {}
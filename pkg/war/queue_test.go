@@ -0,0 +1,64 @@
+package war
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueueMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode string
+		// 第一次 run 之后的输出
+		want []string
+	}{
+		// 被取消的 run 的变化并入下一次 run
+		{QueueRestart, []string{"run: a.go", "run: a.go b.go c.go", "done"}},
+		{QueueQueue, []string{"run: a.go", "done", "run: b.go c.go", "done"}},
+		{QueueDrop, []string{"run: a.go", "done"}},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			root := t.TempDir()
+			hold := filepath.Join(root, "hold")
+			// hold 存在时 run 不会结束
+			w, err := NewWatchAndRun(
+				WithRoot(root),
+				WithIncludeExts([]string{".go"}),
+				WithDelay(50*time.Millisecond),
+				WithQueueMode(tc.mode),
+				WithTermTimeout(time.Second),
+				WithRun([]string{`echo run: $(for f in $WAR_CHANGED_FILES; do basename $f; done | sort) >> out.txt; while [ -e hold ]; do sleep 0.01; done; echo done >> out.txt`}),
+			)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Start(context.Background()))
+			defer w.Stop(context.Background())
+			lines := func() []string {
+				bs, _ := os.ReadFile(filepath.Join(root, "out.txt"))
+				return strings.Split(strings.TrimSpace(string(bs)), "\n")
+			}
+			assert.Eventually(t, func() bool { return len(lines()) == 2 }, 3*time.Second, 10*time.Millisecond)
+
+			assert.NoError(t, os.WriteFile(hold, nil, 0644))
+			assert.NoError(t, os.WriteFile(filepath.Join(root, "a.go"), nil, 0644))
+			assert.Eventually(t, func() bool { return len(lines()) == 3 }, 3*time.Second, 10*time.Millisecond)
+			// run 期间的变化
+			assert.NoError(t, os.WriteFile(filepath.Join(root, "b.go"), nil, 0644))
+			assert.NoError(t, os.WriteFile(filepath.Join(root, "c.go"), nil, 0644))
+			if tc.mode == QueueRestart {
+				// 等重启后的 run 看到所有变化再放行
+				assert.Eventually(t, func() bool { return lines()[len(lines())-1] == tc.want[1] }, 3*time.Second, 10*time.Millisecond)
+			} else {
+				time.Sleep(200 * time.Millisecond)
+			}
+			assert.NoError(t, os.Remove(hold))
+			assert.Eventually(t, func() bool { return len(lines()) == 2+len(tc.want) }, 3*time.Second, 10*time.Millisecond)
+			// 之后不再有 run
+			time.Sleep(300 * time.Millisecond)
+			assert.Equal(t, append([]string{"run:", "done"}, tc.want...), lines())
+		})
+	}
+}
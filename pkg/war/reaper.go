@@ -0,0 +1,27 @@
+package war
+
+import "os/exec"
+
+const (
+	// ReapSubreaper makes war a child subreaper (PR_SET_CHILD_SUBREAPER).
+	ReapSubreaper = "subreaper"
+	// ReapCgroup places each run in its own cgroup v2.
+	ReapCgroup = "cgroup"
+)
+
+type (
+	// reaper cleans up the descendants of a run which escaped its process group (setsid, double fork).
+	reaper interface {
+		// prepare is called before the run starts.
+		prepare(execCmd *exec.Cmd) error
+		// cleanup is called after the run exits or is stopped. If kill is true, all remaining descendants are killed.
+		cleanup(hint string, execCmd *exec.Cmd, kill bool)
+	}
+)
+
+func processPid(execCmd *exec.Cmd) int {
+	if execCmd.Process == nil {
+		return 0
+	}
+	return execCmd.Process.Pid
+}
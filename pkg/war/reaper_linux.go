@@ -0,0 +1,246 @@
+//go:build linux
+
+package war
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/samber/lo"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type (
+	// subreaper makes war a child subreaper, so descendants which escaped the process group are reparented to war.
+	subreaper struct {
+		w *WatchAndRun
+		// adopted 是之前的 run 正常结束后留下的后台进程, 它们不属于之后的 run, 不会被杀掉
+		// pid -> 启动时间, 用于识别被复用的 pid
+		adopted map[int]uint64
+	}
+	// cgroupReaper places each run in its own cgroup v2.
+	cgroupReaper struct {
+		w      *WatchAndRun
+		parent string
+		seq    int
+		// current 是当前 run 所在的 cgroup 目录
+		current string
+		// stale 是里面还有进程的 cgroup, 等它们空了再删除
+		stale []string
+	}
+	procStat struct {
+		pid   int
+		state byte
+		ppid  int
+		pgrp  int
+		// start 是进程的启动时间 (clock ticks)
+		start uint64
+	}
+)
+
+func newReaper(w *WatchAndRun, mode string) (reaper, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case ReapSubreaper:
+		if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			return nil, fmt.Errorf("set child subreaper error: %v", err)
+		}
+		return &subreaper{w: w, adopted: make(map[int]uint64)}, nil
+	case ReapCgroup:
+		parent, err := selfCgroupDir()
+		if err != nil {
+			return nil, err
+		}
+		return &cgroupReaper{w: w, parent: parent}, nil
+	default:
+		return nil, fmt.Errorf("unknown reap mode: %s", mode)
+	}
+}
+
+func (r *subreaper) prepare(*exec.Cmd) error {
+	return nil
+}
+
+// cleanup kills the orphans reparented to war since the previous run if kill is true.
+// Otherwise, they are left running as background processes of this run.
+func (r *subreaper) cleanup(hint string, execCmd *exec.Cmd, kill bool) {
+	self := os.Getpid()
+	var killed []int
+	// 这次之后仍然存活的孤儿进程, 已经退出的 adopted 进程不会留在里面
+	adopted := make(map[int]uint64)
+	// 杀掉孤儿进程后, 它的子进程又会被 reparent 到我们这里, 因此需要多轮
+	for round := 0; round < 10; round++ {
+		orphans := 0
+		for _, stat := range listProcs() {
+			if stat.ppid != self || stat.pid == processPid(execCmd) {
+				continue
+			}
+			if stat.state == 'Z' {
+				unix.Wait4(stat.pid, nil, unix.WNOHANG, nil)
+				continue
+			}
+			if start, ok := r.adopted[stat.pid]; ok && start == stat.start {
+				adopted[stat.pid] = stat.start
+				continue
+			}
+			if !kill {
+				adopted[stat.pid] = stat.start
+				continue
+			}
+			orphans++
+			unix.Kill(stat.pid, unix.SIGKILL)
+			var ws unix.WaitStatus
+			unix.Wait4(stat.pid, &ws, 0, nil)
+			killed = append(killed, stat.pid)
+		}
+		if orphans == 0 {
+			break
+		}
+	}
+	r.adopted = adopted
+	if len(killed) > 0 {
+		r.w.logWarn("%s: killed %d orphaned descendants: %v", hint, len(killed), killed)
+	}
+}
+
+func (r *cgroupReaper) prepare(execCmd *exec.Cmd) error {
+	r.seq++
+	dir := filepath.Join(r.parent, fmt.Sprintf("war-%d-%d", os.Getpid(), r.seq))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return fmt.Errorf("create cgroup error: %v", err)
+	}
+	fd, err := unix.Open(dir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("open cgroup error: %v", err)
+	}
+	// fd 只在 clone 时使用, 进程启动后就可以关闭了, 这里延迟到 cleanup 时关闭
+	r.current = dir
+	if execCmd.SysProcAttr == nil {
+		execCmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	execCmd.SysProcAttr.UseCgroupFD = true
+	execCmd.SysProcAttr.CgroupFD = fd
+	return nil
+}
+
+func (r *cgroupReaper) cleanup(hint string, execCmd *exec.Cmd, kill bool) {
+	if execCmd.SysProcAttr != nil && execCmd.SysProcAttr.UseCgroupFD {
+		unix.Close(execCmd.SysProcAttr.CgroupFD)
+		execCmd.SysProcAttr.UseCgroupFD = false
+	}
+	dir := r.current
+	r.current = ""
+	r.stale = lo.Filter(r.stale, func(stale string, _ int) bool {
+		return os.Remove(stale) != nil
+	})
+	if dir == "" {
+		return
+	}
+	pids := cgroupProcs(dir)
+	if len(pids) > 0 && kill {
+		// 不在 run 进程组里的进程, 都是逃逸出去的
+		var escaped []int
+		for _, pid := range pids {
+			if stat, ok := readProcStat(pid); ok && stat.pgrp != processPid(execCmd) {
+				escaped = append(escaped, pid)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644); err != nil {
+			// cgroup.kill 需要 linux 5.14+
+			for _, pid := range pids {
+				unix.Kill(pid, unix.SIGKILL)
+			}
+		}
+		if len(escaped) > 0 {
+			r.w.logWarn("%s: killed %d descendants escaped from the process group: %v", hint, len(escaped), escaped)
+		}
+		for i := 0; i < 100 && len(cgroupProcs(dir)) > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := os.Remove(dir); err != nil {
+		r.w.logWarn("%s: %d processes are left in cgroup %s", hint, len(cgroupProcs(dir)), dir)
+		r.stale = append(r.stale, dir)
+	}
+}
+
+// selfCgroupDir returns the cgroup v2 dir of war.
+func selfCgroupDir() (string, error) {
+	bs, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			// 混合模式下 cgroup v2 挂载在 /sys/fs/cgroup/unified
+			for _, mount := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+				dir := filepath.Join(mount, path)
+				if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err == nil {
+					return dir, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 is not available")
+}
+
+func cgroupProcs(dir string) []int {
+	bs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(bs)) {
+		if pid, err := strconv.Atoi(line); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+func listProcs() []procStat {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var ret []procStat
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if stat, ok := readProcStat(pid); ok {
+			ret = append(ret, stat)
+		}
+	}
+	return ret
+}
+
+func readProcStat(pid int) (procStat, bool) {
+	bs, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, false
+	}
+	// comm 可能包含空格和括号, 因此从最后一个 ')' 之后开始解析
+	i := bytes.LastIndexByte(bs, ')')
+	if i < 0 {
+		return procStat{}, false
+	}
+	fields := strings.Fields(string(bs[i+1:]))
+	if len(fields) < 20 {
+		return procStat{}, false
+	}
+	stat := procStat{pid: pid, state: fields[0][0]}
+	stat.ppid, _ = strconv.Atoi(fields[1])
+	stat.pgrp, _ = strconv.Atoi(fields[2])
+	stat.start, _ = strconv.ParseUint(fields[19], 10, 64)
+	return stat, true
+}
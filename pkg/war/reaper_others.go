@@ -0,0 +1,12 @@
+//go:build !linux
+
+package war
+
+import "fmt"
+
+func newReaper(w *WatchAndRun, mode string) (reaper, error) {
+	if mode == "" {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("reap mode %s is only supported on linux", mode)
+}
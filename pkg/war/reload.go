@@ -0,0 +1,45 @@
+package war
+
+import (
+	"os/exec"
+	"path/filepath"
+)
+
+// reloadChanges sends the reload signal of command to the process group if all pending changes match its reload rules.
+// Otherwise, the changes are put back and false is returned.
+func (w *WatchAndRun) reloadChanges(hint string, command Command, execCmd *exec.Cmd) bool {
+	changes := w.takeChanges()
+	if changes.len() == 0 {
+		return true
+	}
+	for _, c := range changes.list() {
+		if !w.shouldReload(command, c.Path) {
+			w.logWarn("%s: %s does not match reload rules", hint, c.Path)
+			w.requeueChanges(changes)
+			return false
+		}
+	}
+	if err := killProcessGroup(execCmd.Process, command.ReloadSignal); err != nil {
+		w.logError("%s: send %s error: %+v", hint, signalName(command.ReloadSignal), err)
+		w.requeueChanges(changes)
+		return false
+	}
+	w.logSuccess("%s: reload %d changes, send %s", hint, changes.len(), signalName(command.ReloadSignal))
+	return true
+}
+
+func (w *WatchAndRun) shouldReload(command Command, path string) bool {
+	if command.ReloadOn == nil {
+		return true
+	}
+	return command.ReloadOn.MatchesPath(w.relPath(path))
+}
+
+// relPath returns the slash separated path relative to root.
+func (w *WatchAndRun) relPath(path string) string {
+	rel, err := filepath.Rel(w.options.root, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package war
+
+import (
+	"context"
+	gitignore "github.com/sabhiram/go-gitignore"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadSignalOnlyLast(t *testing.T) {
+	_, err := NewWatchAndRun(WithCommands([]Command{{Cmd: "a", ReloadSignal: syscall.SIGHUP}, {Cmd: "b"}}))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	root := t.TempDir()
+	out := filepath.Join(root, "out.txt")
+	command := Command{
+		Cmd:          `trap 'echo hup >> out.txt' HUP; echo start >> out.txt; while true; do sleep 0.05; done`,
+		ReloadSignal: syscall.SIGHUP,
+		ReloadOn:     gitignore.CompileIgnoreLines("*.toml"),
+	}
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithIncludeExts([]string{".go", ".toml"}),
+		WithDelay(50*time.Millisecond),
+		WithTermTimeout(time.Second),
+		WithCommands([]Command{command}),
+	)
+	assert.NoError(t, err)
+	assert.True(t, w.shouldReload(command, filepath.Join(root, "conf", "a.toml")))
+	assert.False(t, w.shouldReload(command, filepath.Join(root, "main.go")))
+
+	assert.NoError(t, w.Start(context.Background()))
+	defer w.Stop(context.Background())
+	content := func() string {
+		bs, _ := os.ReadFile(out)
+		return strings.TrimSpace(string(bs))
+	}
+	assert.Eventually(t, func() bool { return content() == "start" }, 3*time.Second, 10*time.Millisecond)
+	// 匹配 reload_on 的变化只发送信号
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.toml"), nil, 0644))
+	assert.Eventually(t, func() bool { return content() == "start\nhup" }, 3*time.Second, 10*time.Millisecond)
+	// 其他变化重启进程
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "main.go"), nil, 0644))
+	assert.Eventually(t, func() bool { return content() == "start\nhup\nstart" }, 3*time.Second, 10*time.Millisecond)
+}
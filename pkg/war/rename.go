@@ -0,0 +1,107 @@
+package war
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// renameWindow is how long a rename event waits for the create event of its destination.
+// A move within the watched dirs produces the two events back to back, a move out of them produces no create event.
+const renameWindow = 50 * time.Millisecond
+
+type (
+	// pendingRename is a watched path which is moved away, its destination is unknown yet.
+	pendingRename struct {
+		path    string
+		info    *watchedInfo
+		timeout <-chan time.Time
+	}
+)
+
+// pairRename pairs the pending rename with e, it returns true if e is the create event of the destination.
+// Otherwise, the pending rename is handled as a removal.
+func (w *WatchAndRun) pairRename(e fsnotify.Event) bool {
+	from := w.renaming
+	w.renaming = nil
+	if e.Has(fsnotify.Create) && w.onRename(from, e.Name) {
+		return true
+	}
+	w.removeWatched(from.path, true)
+	return false
+}
+
+// flushRename handles the pending rename as a removal, it is moved out of the watched dirs.
+func (w *WatchAndRun) flushRename() {
+	from := w.renaming
+	w.renaming = nil
+	w.removeWatched(from.path, true)
+}
+
+// onRename moves the watched state of from to to, it returns false if to is not the same entry as from,
+// or to is not watched.
+func (w *WatchAndRun) onRename(from *pendingRename, to string) bool {
+	stat, err := os.Lstat(to)
+	// symlink 的监听方式和普通文件不同, 仍然按删除加新建处理
+	if err != nil || stat.Mode()&fs.ModeSymlink != 0 || from.info.symlink || stat.IsDir() == from.info.file {
+		return false
+	}
+	// 紧接着的 create 也可能是无关的, 比如移出去之后又新建了一个文件. inode 已知时必须相同, 否则文件名必须相同
+	ino := inode(to)
+	if from.info.ino != 0 && ino != 0 {
+		if from.info.ino != ino {
+			return false
+		}
+	} else if filepath.Base(from.path) != filepath.Base(to) {
+		return false
+	}
+	if from.info.file {
+		if !w.shouldWatchFile(to) {
+			return false
+		}
+		w.unwatch(from.path, func(string, *watchedInfo) {})
+		w.watched.set(to, &watchedInfo{file: true, ino: ino})
+		w.logChange("rename file %s → %s", from.path, to)
+		w.notifyChange(Change{Path: to, Kind: ChangeRenamed, From: from.path})
+		return true
+	}
+	if !w.shouldWatchDir(to) {
+		return false
+	}
+	if _, ok := w.watched.get(to); ok {
+		// 覆盖了一个被监听的空目录
+		w.removeWatched(to, true)
+	}
+	w.logChange("rename dir %s → %s (%d watched paths)", from.path, to, w.watched.count(from.path))
+	// 记下原来的文件, 移动之后再按相对路径配对
+	files := make(map[string]bool)
+	w.unwatch(from.path, func(path string, info *watchedInfo) {
+		if info.file {
+			rel, _ := filepath.Rel(from.path, path)
+			files[rel] = true
+		} else {
+			w.watcher.Remove(path)
+		}
+	})
+	// 目的地的忽略规则可能不同, 因此重新遍历
+	w.addDir(to, true, false)
+	w.watched.walk(to, func(path string, info *watchedInfo) bool {
+		if !info.file {
+			return true
+		}
+		rel, _ := filepath.Rel(to, path)
+		if files[rel] {
+			delete(files, rel)
+			w.notifyChange(Change{Path: path, Kind: ChangeRenamed, From: filepath.Join(from.path, rel)})
+		} else {
+			w.notifyRun(path, ChangeCreated)
+		}
+		return true
+	})
+	for rel := range files {
+		w.notifyRun(filepath.Join(from.path, rel), ChangeRemoved)
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// handleEvents handles fs events like handleLoop, until no event arrives within 200ms.
+func handleEvents(w *WatchAndRun) {
+	for {
+		var renameCh <-chan time.Time
+		if w.renaming != nil {
+			renameCh = w.renaming.timeout
+		}
+		select {
+		case e := <-w.watcher.Events():
+			w.onFsEvent(e)
+		case <-renameCh:
+			w.flushRename()
+		case <-time.After(200 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "d"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.go"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "d", "x.go"), nil, 0644))
+	// queue 模式下 notifyRun 不会等待 runLoop 取消 run
+	w, err := NewWatchAndRun(WithRoot(root), WithQueueMode(QueueQueue))
+	assert.NoError(t, err)
+	defer w.watcher.Close()
+	assert.True(t, w.addDir(root, true, false))
+
+	assert.NoError(t, os.Rename(filepath.Join(root, "a.go"), filepath.Join(root, "b.go")))
+	handleEvents(w)
+	assert.Equal(t, []Change{{filepath.Join(root, "b.go"), ChangeRenamed, filepath.Join(root, "a.go")}}, w.takeChanges().list())
+
+	assert.NoError(t, os.Rename(filepath.Join(root, "d"), filepath.Join(root, "e")))
+	handleEvents(w)
+	assert.Equal(t, []Change{{filepath.Join(root, "e", "x.go"), ChangeRenamed, filepath.Join(root, "d", "x.go")}}, w.takeChanges().list())
+	_, ok := w.watched.get(filepath.Join(root, "e", "x.go"))
+	assert.True(t, ok)
+
+	// 移出去之后紧接着新建的无关文件不会被当作 rename 的目的地
+	assert.NoError(t, os.Rename(filepath.Join(root, "b.go"), filepath.Join(outside, "b.go")))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "c.go"), nil, 0644))
+	handleEvents(w)
+	assert.Equal(t, []Change{
+		{filepath.Join(root, "b.go"), ChangeRemoved, ""},
+		{filepath.Join(root, "c.go"), ChangeCreated, ""},
+	}, w.takeChanges().list())
+
+	assert.NoError(t, os.Rename(filepath.Join(root, "e"), filepath.Join(outside, "e")))
+	handleEvents(w)
+	assert.Equal(t, []Change{{filepath.Join(root, "e", "x.go"), ChangeRemoved, ""}}, w.takeChanges().list())
+	assert.Equal(t, 2, w.watched.len())
+}
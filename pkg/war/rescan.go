@@ -0,0 +1,61 @@
+package war
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+)
+
+// rescan resyncs the watched paths after the event queue of the watcher overflowed and events were lost.
+// Created and removed paths are found by walking the watched dirs, then a run is scheduled.
+// Modifications can not be detected, so they are not in the changes of the run.
+func (w *WatchAndRun) rescan() {
+	w.logWarn("event queue overflowed, rescan the watched dirs")
+	if w.renaming != nil {
+		w.flushRename()
+	}
+	var gone, dirs []string
+	for _, r := range w.roots {
+		w.watched.walk(r.path, func(path string, info *watchedInfo) bool {
+			if _, err := os.Lstat(path); err != nil {
+				gone = append(gone, path)
+				return false
+			}
+			if !info.file {
+				dirs = append(dirs, path)
+			}
+			return true
+		})
+	}
+	for _, path := range gone {
+		w.removeWatched(path, false)
+	}
+	for _, r := range w.roots {
+		if _, ok := w.watched.get(r.path); !ok && r.file {
+			// 文件 root (比如 env file) 可能在丢失的事件里被创建了
+			w.onFsEvent(fsnotify.Event{Name: r.path, Op: fsnotify.Create})
+		}
+	}
+	for _, dir := range lo.Uniq(dirs) {
+		if _, ok := w.watched.get(dir); !ok {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			path := filepath.Join(dir, e.Name())
+			if _, ok := w.watched.get(path); ok {
+				if e.Name() == ".gitignore" && w.options.gitIgnore {
+					w.onGitIgnoreChanged(dir)
+				}
+				continue
+			}
+			// 和 create 事件一样处理, 新的目录会被遍历, 新的文件会被报告为 created
+			w.onFsEvent(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		}
+	}
+	w.triggerRun()
+}
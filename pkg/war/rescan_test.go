@@ -0,0 +1,39 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRescan(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "d"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "a.go"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "d", "x.go"), nil, 0644))
+	w, err := NewWatchAndRun(WithRoot(root), WithQueueMode(QueueQueue))
+	assert.NoError(t, err)
+	defer w.watcher.Close()
+	assert.True(t, w.addDir(root, true, false))
+
+	// 这些变化的事件都丢失了
+	assert.NoError(t, os.Remove(filepath.Join(root, "a.go")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(root, "d")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "e"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "e", "y.go"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "b.go"), nil, 0644))
+	w.rescan()
+	assert.Equal(t, []Change{
+		{filepath.Join(root, "a.go"), ChangeRemoved, ""},
+		{filepath.Join(root, "b.go"), ChangeCreated, ""},
+		{filepath.Join(root, "d", "x.go"), ChangeRemoved, ""},
+		{filepath.Join(root, "e", "y.go"), ChangeCreated, ""},
+	}, w.takeChanges().list())
+	_, ok := w.watched.get(filepath.Join(root, "e", "y.go"))
+	assert.True(t, ok)
+	_, ok = w.watched.get(filepath.Join(root, "d"))
+	assert.False(t, ok)
+	// 安排了一次 run
+	assert.Len(t, w.runCh, 1)
+}
@@ -0,0 +1,114 @@
+package war
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// WatchPath is a file or dir watched in addition to root.
+	// Ignore are gitignore style rules matched against paths relative to Path.
+	WatchPath struct {
+		Path   string
+		Ignore []string
+	}
+	// watchRoot 是一个被监听的根路径, roots[0] 是主 root
+	watchRoot struct {
+		path string
+		// file 表示 path 是一个文件, 此时监听的是它的父目录, 父目录下的其他文件会被忽略
+		file bool
+		// extra 的路径不受 include_exts, include 和全局忽略规则的影响, 也不读取 .gitignore
+		extra bool
+		// ignoreRules 可能为 nil
+		ignoreRules *ignoreSource
+		// gitIgnorer 可能为 nil
+		gitIgnorer *GitIgnorer
+	}
+)
+
+// newWatchRoots creates the primary root, the extra roots, the extra watch paths and the env files.
+// Relative paths are relative to the primary root.
+func newWatchRoots(options options) []*watchRoot {
+	newRoot := func(p WatchPath, extra bool, shared []*IgnoreRule) *watchRoot {
+		path := p.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(options.root, path)
+		}
+		r := &watchRoot{path: filepath.Clean(path), extra: extra}
+		rules := append(append([]*IgnoreRule{}, shared...), compileIgnoreRules(p.Path+" ignore", p.Ignore)...)
+		if len(rules) > 0 {
+			r.ignoreRules = &ignoreSource{dir: r.path, rules: rules}
+		}
+		return r
+	}
+	roots := []*watchRoot{newRoot(WatchPath{Path: options.root}, false, options.ignoreRules)}
+	for _, p := range options.roots {
+		roots = append(roots, newRoot(p, false, options.ignoreRules))
+	}
+	for _, p := range options.extraWatch {
+		roots = append(roots, newRoot(p, true, nil))
+	}
+	// env files 总是被监听, 即使它们在 roots 之外, 或者在隐藏的或被忽略的目录下. 它们可以不存在
+	for _, path := range options.envFiles {
+		roots = append(roots, &watchRoot{path: filepath.Clean(path), file: true, extra: true})
+	}
+	return roots
+}
+
+// rel returns the slash separated path relative to r.
+func (r *watchRoot) rel(path string) string {
+	rel, err := filepath.Rel(r.path, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// contains reports whether path is r or inside r.
+func (r *watchRoot) contains(path string) bool {
+	return path == r.path || strings.HasPrefix(path, r.path+string(filepath.Separator))
+}
+
+// rootOf returns the innermost root containing path, or nil if path is outside all roots.
+func (w *WatchAndRun) rootOf(path string) *watchRoot {
+	var ret *watchRoot
+	for _, r := range w.roots {
+		if r.contains(path) && (ret == nil || len(r.path) > len(ret.path)) {
+			ret = r
+		}
+	}
+	return ret
+}
+
+// addRoot watches r, a file root is watched by watching its parent dir.
+// A file root created by newWatchRoots (an env file) may not exist, it is watched once it is created.
+func (w *WatchAndRun) addRoot(r *watchRoot) error {
+	if _, ok := w.watched.get(r.path); ok {
+		// 被其他 root 包含了
+		return nil
+	}
+	stat, err := os.Stat(r.path)
+	switch {
+	case err != nil && !(r.file && os.IsNotExist(err)):
+		return err
+	case err == nil && stat.IsDir():
+		if w.options.gitIgnore && !r.extra {
+			r.gitIgnorer = NewGitIgnorer(r.path)
+		}
+		w.addDir(r.path, true, false)
+		return nil
+	case err == nil && !stat.Mode().IsRegular():
+		return fmt.Errorf("%s is neither a file nor a dir", r.path)
+	}
+	r.file = true
+	// 监听父目录而不是文件本身, 这样编辑器通过 rename 替换文件后仍然能收到事件
+	if err := w.watcher.Add(filepath.Dir(r.path)); err != nil {
+		return err
+	}
+	if err == nil {
+		w.maybeAddFile(r.path, stat.Mode(), false)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package war
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ShellNone splits the command into argv and executes it directly, without a shell.
+	ShellNone = "none"
+	// DefaultShell is used when shell is empty.
+	DefaultShell = "bash"
+)
+
+const (
+	StdinNull    = "null"
+	StdinInherit = "inherit"
+)
+
+var shells = map[string]struct{}{"sh": {}, "bash": {}, "zsh": {}, "fish": {}, ShellNone: {}}
+
+// ValidateShell checks that shell is one of sh, bash, zsh, fish and none.
+func ValidateShell(shell string) error {
+	if _, ok := shells[shell]; !ok {
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+	return nil
+}
+
+// newExecCmd creates an exec.Cmd running cmd with shell.
+// If shell is "none", variables like $FOO in cmd are expanded with env, then cmd is split into argv.
+func newExecCmd(shell string, cmd string, env []string) (*exec.Cmd, error) {
+	if shell == "" {
+		shell = DefaultShell
+	}
+	if shell != ShellNone {
+		return exec.Command(shell, "-c", cmd), nil
+	}
+	args, err := splitArgs(cmd, func(key string) string {
+		return lookupEnv(env, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("command is empty")
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// lookupEnv returns the last value of key in env, like exec.Cmd does.
+func lookupEnv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, ok := strings.Cut(env[i], "="); ok && k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitArgs splits s into args like a posix shell: single quotes, double quotes and backslash escapes are supported.
+// Variables are expanded with mapping, except in single quotes.
+func splitArgs(s string, mapping func(string) string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+					cur.WriteByte(s[i])
+				} else if s[i] == '$' {
+					i += expandVar(s[i:], mapping, &cur) - 1
+				} else {
+					cur.WriteByte(s[i])
+				}
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+		case c == '\\':
+			if i+1 < len(s) {
+				i++
+				cur.WriteByte(s[i])
+			}
+			inArg = true
+		case c == '$':
+			i += expandVar(s[i:], mapping, &cur) - 1
+			inArg = true
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// expandVar expands the variable at the beginning of s ($FOO or ${FOO}) into cur, and returns the number of bytes consumed.
+func expandVar(s string, mapping func(string) string, cur *strings.Builder) int {
+	if len(s) > 1 && s[1] == '{' {
+		if end := strings.IndexByte(s, '}'); end > 0 {
+			cur.WriteString(mapping(s[2:end]))
+			return end + 1
+		}
+	}
+	n := 1
+	for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || n > 1 && s[n] >= '0' && s[n] <= '9') {
+		n++
+	}
+	if n == 1 {
+		cur.WriteByte('$')
+		return 1
+	}
+	cur.WriteString(mapping(s[1:n]))
+	return n
+}
+
+// ValidateStdin checks that stdin is one of null, inherit and file:/path/to/file.
+func ValidateStdin(stdin string) error {
+	if stdin == "" || stdin == StdinNull || stdin == StdinInherit || strings.HasPrefix(stdin, "file:") {
+		return nil
+	}
+	return fmt.Errorf("unsupported stdin: %s", stdin)
+}
+
+// openStdin opens the stdin of a command, nil means the null device.
+func openStdin(stdin string, dir string) (*os.File, error) {
+	switch {
+	case stdin == "" || stdin == StdinNull:
+		return nil, nil
+	case stdin == StdinInherit:
+		return os.Stdin, nil
+	case strings.HasPrefix(stdin, "file:"):
+		path := stdin[len("file:"):]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return os.Open(path)
+	default:
+		return nil, ValidateStdin(stdin)
+	}
+}
+
+func (w *WatchAndRun) commandShell(command Command) string {
+	if command.Shell != "" {
+		return command.Shell
+	}
+	return w.options.shell
+}
@@ -0,0 +1,24 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	mapping := func(key string) string {
+		return map[string]string{"DIR": "/a b", "X": "x"}[key]
+	}
+	args, err := splitArgs(`go test  -run 'Test A' "$DIR/c" ${X}y \$X a\ b '$X' $`, mapping)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go", "test", "-run", "Test A", "/a b/c", "xy", "$X", "a b", "$X", "$"}, args)
+
+	args, err = splitArgs(`echo "" ''`, mapping)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"echo", "", ""}, args)
+
+	_, err = splitArgs(`echo 'a`, mapping)
+	assert.Error(t, err)
+	_, err = splitArgs(`echo "a`, mapping)
+	assert.Error(t, err)
+}
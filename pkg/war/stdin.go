@@ -0,0 +1,33 @@
+package war
+
+import (
+	"io"
+	"os"
+)
+
+// forwardStdinLoop forwards the stdin of war to the currently running command.
+// The target is switched to the new process after each restart, input is dropped when no command is running.
+func (w *WatchAndRun) forwardStdinLoop() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			w.stdinMu.Lock()
+			target := w.stdinTarget
+			w.stdinMu.Unlock()
+			if target != nil {
+				// 不持有锁写入, 避免子进程不读 stdin 时阻塞 runCmd
+				target.Write(buf[:n])
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *WatchAndRun) setStdinTarget(target io.Writer) {
+	w.stdinMu.Lock()
+	defer w.stdinMu.Unlock()
+	w.stdinTarget = target
+}
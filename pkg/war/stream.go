@@ -0,0 +1,59 @@
+package war
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+)
+
+// A streaming run process exits with streamRestartExitCode to ask war for a full restart.
+const streamRestartExitCode = 75
+
+var errRestartRequested = errors.New("restart requested")
+
+type (
+	// streamEvent is written to the stdin of a streaming run process as a line of json.
+	streamEvent struct {
+		Type    string   `json:"type"`
+		Changes []Change `json:"changes"`
+	}
+)
+
+// startStreamWriter writes change batches received from the returned channel to stdin.
+// Closing the channel closes stdin.
+func (w *WatchAndRun) startStreamWriter(hint string, stdin io.WriteCloser) chan<- []Change {
+	// 写 stdin 可能会因为子进程不读而阻塞, 因此放到单独的协程里, 避免阻塞 runLoop
+	ch := make(chan []Change, 16)
+	go func() {
+		defer stdin.Close()
+		enc := json.NewEncoder(stdin)
+		for changes := range ch {
+			if err := enc.Encode(streamEvent{Type: "change", Changes: changes}); err != nil {
+				w.logError("%s: stream changes error: %+v", hint, err)
+			}
+		}
+	}()
+	return ch
+}
+
+func (w *WatchAndRun) streamChanges(hint string, ch chan<- []Change) {
+	changes := w.takeChanges()
+	if changes.len() == 0 {
+		return
+	}
+	select {
+	case ch <- changes.list():
+		w.logSuccess("%s: stream %d changes", hint, changes.len())
+	default:
+		// 放回去, 下一次 tick 时重试
+		w.logWarn("%s: stream is blocked, retry %d changes later", hint, changes.len())
+		w.requeueChanges(changes)
+		w.triggerRun()
+	}
+}
+
+func isRestartRequest(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == streamRestartExitCode
+}
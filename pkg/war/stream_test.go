@@ -0,0 +1,70 @@
+package war
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	root := t.TempDir()
+	out := filepath.Join(root, "out.txt")
+	// 每个进程读一行变化后请求重启
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithIncludeExts([]string{".go"}),
+		WithDelay(50*time.Millisecond),
+		WithStream(true),
+		WithRun([]string{`echo start >> out.txt; read line; echo "$line" >> out.txt; exit 75`}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Start(context.Background()))
+	defer w.Stop(context.Background())
+	lines := func() []string {
+		bs, _ := os.ReadFile(out)
+		return strings.Split(strings.TrimSpace(string(bs)), "\n")
+	}
+	assert.Eventually(t, func() bool { return len(lines()) == 1 }, 3*time.Second, 10*time.Millisecond)
+
+	for i, name := range []string{"a.go", "b.go"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, name), nil, 0644))
+		// 收到变化的进程退出, 重启后的进程又写入一行 start
+		assert.Eventually(t, func() bool { return len(lines()) == 3+2*i }, 3*time.Second, 10*time.Millisecond)
+		var event streamEvent
+		assert.NoError(t, json.Unmarshal([]byte(lines()[1+2*i]), &event))
+		assert.Equal(t, streamEvent{Type: "change", Changes: []Change{{filepath.Join(root, name), ChangeCreated, ""}}}, event)
+		assert.Equal(t, "start", lines()[2+2*i])
+	}
+}
+
+func TestStreamMaxWait(t *testing.T) {
+	root := t.TempDir()
+	out := filepath.Join(root, "out.txt")
+	w, err := NewWatchAndRun(
+		WithRoot(root),
+		WithIncludeExts([]string{".go"}),
+		WithDelay(500*time.Millisecond),
+		WithMaxWait(200*time.Millisecond),
+		WithStream(true),
+		WithRun([]string{`while read line; do echo "$line" >> out.txt; done`}),
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Start(context.Background()))
+	defer w.Stop(context.Background())
+	count := func() int {
+		bs, _ := os.ReadFile(out)
+		return strings.Count(string(bs), "\n")
+	}
+	// 文件一直在变化, max wait 仍然保证变化被及时交付
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, os.WriteFile(filepath.Join(root, "a.go"), []byte{byte(i)}, 0644))
+		time.Sleep(50 * time.Millisecond)
+	}
+	// 只按 delay 的话一次都不会交付
+	assert.GreaterOrEqual(t, count(), 2)
+}
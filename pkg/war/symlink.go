@@ -0,0 +1,42 @@
+package war
+
+import (
+	"os"
+)
+
+// fileID identifies a dir regardless of the path (symlinks) used to reach it.
+type fileID struct {
+	dev  uint64
+	ino  uint64
+	path string
+}
+
+// addSymlink watches the target of the symlink at path. The target is watched under path,
+// so events, logs and ignore rules use the symlinked paths.
+func (w *WatchAndRun) addSymlink(path string, notifyRun bool) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		w.logWarn("skip broken symlink %s", path)
+		return
+	}
+	if stat.IsDir() {
+		if _, ok := w.watched.get(path); !ok && w.shouldWatchDir(path) {
+			w.addDir(path, true, notifyRun)
+		}
+		return
+	}
+	if !stat.Mode().IsRegular() || !w.shouldWatchFile(path) {
+		return
+	}
+	if _, ok := w.watched.get(path); !ok {
+		// 目标文件可能不在被监听的目录里, 因此单独监听它 (inotify 会跟随 symlink), 事件的路径仍然是 path
+		if err := w.watcher.Add(path); err != nil {
+			w.logError("watch symlink error %s %+v", path, err)
+			return
+		}
+	}
+	w.maybeAddFile(path, stat.Mode(), notifyRun)
+	if info, ok := w.watched.get(path); ok {
+		info.symlink = true
+	}
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package war
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// inode returns the inode of path, symlinks are not followed. It returns 0 if it is unknown.
+func inode(path string) uint64 {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return 0
+	}
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// dirID returns the device and inode of dir, symlinks are followed.
+func dirID(dir string) (fileID, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return fileID{}, err
+	}
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, fmt.Errorf("unsupported stat of %s", dir)
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, nil
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package war
+
+import (
+	"path/filepath"
+)
+
+// inode returns 0, inodes are not available on windows.
+func inode(string) uint64 {
+	return 0
+}
+
+// dirID returns the real path of dir, inodes are not available on windows.
+func dirID(dir string) (fileID, error) {
+	path, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fileID{}, err
+	}
+	return fileID{path: path}, nil
+}
@@ -0,0 +1,81 @@
+package war
+
+import (
+	gitignore "github.com/sabhiram/go-gitignore"
+	"time"
+)
+
+type (
+	// Timing controls when a run is triggered after file changes.
+	Timing struct {
+		// Delay is the debounce interval, a run is triggered when there is no change for Delay.
+		Delay time.Duration
+		// MaxWait guarantees a run at most MaxWait after the first pending change, even if files keep changing.
+		// Zero means no limit.
+		MaxWait time.Duration
+		// If Leading is true, the first change after a quiet period (no run within Delay) triggers a run immediately.
+		Leading bool
+		// MinInterval is the minimum interval between the starts of two runs.
+		MinInterval time.Duration
+	}
+	// TimingRule overrides the global timing for changes matching Match.
+	TimingRule struct {
+		// Match matches root relative paths.
+		Match  *gitignore.GitIgnore
+		Timing Timing
+	}
+	// debouncer decides when the next run starts, or when changes are delivered to a live command.
+	// It is only used in the goroutine of runLoop.
+	debouncer struct {
+		firstPending time.Time
+		lastRunStart time.Time
+		lastRunEnd   time.Time
+	}
+)
+
+// timingOf returns the timing of the first rule matching path, or the global timing.
+func (w *WatchAndRun) timingOf(path string) Timing {
+	if len(w.options.timingRules) > 0 {
+		rel := w.relPath(path)
+		for _, rule := range w.options.timingRules {
+			if rule.Match.MatchesPath(rel) {
+				return rule.Timing
+			}
+		}
+	}
+	return w.options.timing
+}
+
+// next returns how long to wait before the run, when a change with timing t arrives at now.
+func (d *debouncer) next(t Timing, now time.Time) time.Duration {
+	if d.firstPending.IsZero() {
+		d.firstPending = now
+	}
+	due := now.Add(t.Delay)
+	if t.Leading && now.Sub(d.lastRunEnd) >= t.Delay && d.firstPending.Equal(now) {
+		due = now
+	}
+	if t.MaxWait > 0 {
+		if maxDue := d.firstPending.Add(t.MaxWait); maxDue.Before(due) {
+			due = maxDue
+		}
+	}
+	if t.MinInterval > 0 && !d.lastRunStart.IsZero() {
+		if minDue := d.lastRunStart.Add(t.MinInterval); minDue.After(due) {
+			due = minDue
+		}
+	}
+	if due.Before(now) {
+		return 0
+	}
+	return due.Sub(now)
+}
+
+func (d *debouncer) runStarted(now time.Time) {
+	d.firstPending = time.Time{}
+	d.lastRunStart = now
+}
+
+func (d *debouncer) runFinished(now time.Time) {
+	d.lastRunEnd = now
+}
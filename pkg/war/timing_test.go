@@ -0,0 +1,37 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDebouncer(t *testing.T) {
+	begin := time.Now()
+	at := func(ms int) time.Time { return begin.Add(time.Duration(ms) * time.Millisecond) }
+
+	// 持续变化时, max_wait 保证一定会 run
+	d := debouncer{}
+	timing := Timing{Delay: time.Second, MaxWait: 3 * time.Second}
+	assert.Equal(t, time.Second, d.next(timing, at(0)))
+	assert.Equal(t, time.Second, d.next(timing, at(1500)))
+	assert.Equal(t, 500*time.Millisecond, d.next(timing, at(2500)))
+	assert.Equal(t, time.Duration(0), d.next(timing, at(3200)))
+
+	// leading
+	d = debouncer{}
+	timing = Timing{Delay: time.Second, Leading: true}
+	assert.Equal(t, time.Duration(0), d.next(timing, at(0)))
+	d.runStarted(at(0))
+	d.runFinished(at(100))
+	assert.Equal(t, time.Second, d.next(timing, at(500)))
+	d.runStarted(at(1500))
+	d.runFinished(at(1600))
+	assert.Equal(t, time.Duration(0), d.next(timing, at(3000)))
+
+	// min_interval
+	d = debouncer{}
+	timing = Timing{Delay: 100 * time.Millisecond, MinInterval: time.Second}
+	d.runStarted(at(0))
+	assert.Equal(t, 900*time.Millisecond, d.next(timing, at(100)))
+}
@@ -1,25 +1,73 @@
 package war
 
 import (
+	"errors"
+	"fmt"
 	"github.com/fatih/color"
 	"log"
+	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 	"time"
 )
 
-func killCmd(hint string, execCmd *exec.Cmd, wait <-chan error, termTimeout time.Duration) error {
-	if termTimeout > 0 {
-		err := killProcessGroup(execCmd.Process, syscall.SIGTERM)
-		log.Println(color.YellowString("%s: send SIGTERM: %v", hint, err))
+type (
+	// StopStep is a step of the stop sequence: send Signal, then wait at most Timeout for the process to exit.
+	StopStep struct {
+		Signal  syscall.Signal
+		Timeout time.Duration
+	}
+)
+
+// ParseStopStep parses a stop step in the form of "SIGTERM:5s" or "SIGKILL".
+func ParseStopStep(s string) (StopStep, error) {
+	name, timeout, hasTimeout := strings.Cut(s, ":")
+	sig, err := ParseSignal(name)
+	if err != nil {
+		return StopStep{}, err
+	}
+	step := StopStep{Signal: sig}
+	if hasTimeout {
+		if step.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return StopStep{}, fmt.Errorf("invalid stop step %s: %v", s, err)
+		}
+	}
+	return step, nil
+}
+
+// killCmd sends the signals of steps one by one, until the process exits.
+// If group is true, signals are sent to the whole process group, otherwise only to the leader.
+func killCmd(hint string, execCmd *exec.Cmd, wait <-chan error, steps []StopStep, group bool) error {
+	for i, step := range steps {
+		err := signalProcess(execCmd.Process, step.Signal, group)
+		if step.Signal == syscall.SIGKILL {
+			log.Println(color.RedString("%s: send %s: %v", hint, signalName(step.Signal), err))
+		} else {
+			log.Println(color.YellowString("%s: send %s: %v", hint, signalName(step.Signal), err))
+		}
+		if i == len(steps)-1 {
+			if step.Timeout == 0 {
+				return err
+			}
+		} else if step.Timeout == 0 {
+			continue
+		}
 		select {
-		case <-time.NewTimer(termTimeout).C:
-			log.Println(color.RedString("%s: send SIGKILL: %v", hint, err))
-			return killProcessGroup(execCmd.Process, syscall.SIGKILL)
+		case <-time.NewTimer(step.Timeout).C:
+			if i < len(steps)-1 {
+				log.Println(color.YellowString("%s: still alive after %s, escalate", hint, step.Timeout))
+			}
 		case <-wait:
 			return nil
 		}
-	} else {
-		return killProcessGroup(execCmd.Process, syscall.SIGKILL)
 	}
+	return errors.New("process is still alive after all stop steps")
+}
+
+func signalProcess(process *os.Process, sig syscall.Signal, group bool) error {
+	if group {
+		return killProcessGroup(process, sig)
+	}
+	return process.Signal(sig)
 }
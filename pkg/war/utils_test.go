@@ -0,0 +1,23 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseStopStep(t *testing.T) {
+	step, err := ParseStopStep("SIGINT:2s")
+	assert.NoError(t, err)
+	assert.Equal(t, StopStep{Signal: syscall.SIGINT, Timeout: 2 * time.Second}, step)
+
+	step, err = ParseStopStep("kill")
+	assert.NoError(t, err)
+	assert.Equal(t, StopStep{Signal: syscall.SIGKILL}, step)
+
+	_, err = ParseStopStep("SIGFOO")
+	assert.Error(t, err)
+	_, err = ParseStopStep("SIGTERM:abc")
+	assert.Error(t, err)
+}
@@ -3,8 +3,11 @@
 package war
 
 import (
+	"fmt"
+	"golang.org/x/sys/unix"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 )
 
@@ -19,3 +22,22 @@ func killProcessGroup(process *os.Process, signal syscall.Signal) error {
 	}
 	return syscall.Kill(-pgid, signal)
 }
+
+// ParseSignal parses a signal name like "SIGHUP" or "HUP".
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal: %s", name)
+}
+
+func signalName(sig syscall.Signal) string {
+	if name := unix.SignalName(sig); name != "" {
+		return name
+	}
+	return sig.String()
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/fsnotify/fsnotify"
+	"github.com/samber/lo"
+	"io"
 	"io/fs"
 	"log"
 	"os"
@@ -13,42 +15,111 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type (
 	WatchAndRun struct {
-		watcher     *fsnotify.Watcher
+		watcher     fsWatcher
 		closeCh     chan struct{}
 		runCh       chan struct{}
 		cancelRunCh chan cancel
 		options     options
 		closeMu     sync.Mutex
 		// watched 用于保存我们监听了哪些目录, 以及遇到过哪些文件
-		watched         map[string]*watchedInfo
+		watched *watchedTree
+		// polled 是因为 inotify 限制无法监听的目录 -> 上次轮询到的文件状态
+		polled map[string]map[string]pollState
+		// watchLimitErr 在 watch limit fallback 是 fail 时, 记录启动过程中遇到的 watch limit 错误
+		watchLimitErr      error
+		watchLimitReported bool
+		// rlimitASWarned 表示已经提示过内存限制使用的是 RLIMIT_AS
+		rlimitASWarned bool
+		// failedCh 接收导致 war 无法继续运行的错误, 见 Failed
+		failedCh chan error
+		// dirIDs 是 device+inode -> 被监听的目录, 用于 follow symlinks 时检测循环
+		dirIDs map[fileID]string
+		// renaming 是等待和 create 事件配对的 rename 事件, 只在 handleLoop 中访问
+		renaming        *pendingRename
 		closeWg         sync.WaitGroup
 		firstRunSuccess bool
 		rootWatched     bool
+		// pending 保存 debounce 窗口内累积的文件变化, 由 handleLoop 写入, runLoop 取走
+		pending   *changeSet
+		pendingMu sync.Mutex
+		// pendingTiming 是最后一次变化对应的 timing
+		pendingTiming Timing
+		// debouncer 只在 runLoop 的 goroutine 中访问, live 进程的变化也由它决定何时交付
+		debouncer debouncer
+		// live 为 true 表示正在运行的进程自己处理文件变化 (写入 stdin 或发送 reload 信号), 此时不要取消它
+		live atomic.Bool
+		// running 为 true 表示 runOnce 正在执行
+		running atomic.Bool
+		// reaper 可能为 nil
+		reaper reaper
+		// roots[0] 是主 root, 其余是 WithRoots 和 WithExtraWatch 指定的路径
+		roots []*watchRoot
+		// stdinTarget 是当前正在运行的命令的 stdin, war 的 stdin 会转发给它
+		stdinTarget io.Writer
+		stdinMu     sync.Mutex
 	}
 )
 
+var errCancelled = errors.New("cancelled")
+
 func NewWatchAndRun(opts ...Option) (*WatchAndRun, error) {
-	options := options{delay: time.Second, termTimeout: 3 * time.Second, cancelLast: true}
+	options := options{
+		timing:             Timing{Delay: time.Second},
+		termTimeout:        3 * time.Second,
+		cancelLast:         true,
+		stopGroup:          true,
+		portTimeout:        5 * time.Second,
+		shell:              DefaultShell,
+		watchLimitFallback: WatchLimitDegraded,
+		pollInterval:       time.Second,
+	}
 	for _, o := range opts {
 		o(&options)
 	}
-	watcher, err := fsnotify.NewWatcher()
+	for i, command := range options.run {
+		// 之后的命令要等它退出才能运行, 而 reload 的进程不会退出
+		if command.ReloadSignal != 0 && i != len(options.run)-1 {
+			return nil, fmt.Errorf("reload signal of %q: only the last command can be reloaded", command.Cmd)
+		}
+	}
+	if options.queueMode == "" {
+		options.queueMode = lo.Ternary(options.cancelLast, QueueRestart, QueueQueue)
+	}
+	options.envFiles = lo.Map(options.envFiles, func(path string, _ int) string {
+		return lo.Ternary(filepath.IsAbs(path), path, filepath.Join(options.root, path))
+	})
+	watcher, fallback, err := newFsWatcher(options.backend, options.root)
 	if err != nil {
 		return nil, err
 	}
-	return &WatchAndRun{
+	w := &WatchAndRun{
 		watcher:     watcher,
 		closeCh:     make(chan struct{}),
+		failedCh:    make(chan error, 1),
 		runCh:       make(chan struct{}, 1),
 		cancelRunCh: make(chan cancel, 1),
-		watched:     make(map[string]*watchedInfo),
+		watched:     newWatchedTree(),
+		dirIDs:      make(map[fileID]string),
+		polled:      make(map[string]map[string]pollState),
+		pending:     newChangeSet(),
 		options:     options,
-	}, nil
+	}
+	w.roots = newWatchRoots(options)
+	if fallback != nil {
+		w.logWarn("fanotify can not be used, fall back to inotify: %+v", fallback)
+	}
+	if w.reaper, err = newReaper(w, options.reap); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return w, nil
 }
 
 func (w *WatchAndRun) Start(context.Context) error {
@@ -57,11 +128,23 @@ func (w *WatchAndRun) Start(context.Context) error {
 	} else if !stat.IsDir() {
 		return errors.New("root is not a directory")
 	}
-	w.addDir(w.options.root, true, false)
+	for i, r := range w.roots {
+		if err := w.addRoot(r); err != nil {
+			if i == 0 {
+				return err
+			}
+			w.logError("watch %s error: %+v", r.path, err)
+		}
+	}
+	if w.watchLimitErr != nil {
+		w.watcher.Close()
+		return w.watchLimitErr
+	}
 	w.rootWatched = true
-	select {
-	case w.runCh <- struct{}{}:
-	default:
+	w.triggerRun()
+	if w.options.forwardStdin {
+		// 读 stdin 会一直阻塞, 无法中断, 因此不计入 closeWg
+		go w.forwardStdinLoop()
 	}
 	w.closeWg.Add(2)
 	go w.runLoop()
@@ -69,6 +152,20 @@ func (w *WatchAndRun) Start(context.Context) error {
 	return nil
 }
 
+// Failed returns a channel which receives the error making war unable to keep working after Start,
+// such as the watch limit is reached when the watch limit fallback is WatchLimitFail. The caller should Stop war then.
+func (w *WatchAndRun) Failed() <-chan error {
+	return w.failedCh
+}
+
+func (w *WatchAndRun) fail(err error) {
+	select {
+	case w.failedCh <- err:
+	default:
+	}
+}
+
+// Stop stops war, it may be called without Start (or after Start fails) to release the watcher.
 func (w *WatchAndRun) Stop(context.Context) error {
 	w.closeMu.Lock()
 	defer w.closeMu.Unlock()
@@ -80,30 +177,63 @@ func (w *WatchAndRun) Stop(context.Context) error {
 	if err := w.watcher.Close(); err != nil {
 		w.logError("close watcher error: %+v", err)
 	}
+	if !w.rootWatched {
+		// runLoop 和 handleLoop 还没有启动
+		close(w.closeCh)
+		return nil
+	}
 	w.cancelRun()
 	close(w.closeCh)
 	w.closeWg.Wait()
 	return nil
 }
 
-func (w *WatchAndRun) addDir(dir string, dfs bool, notifyRun bool) {
-	if _, ok := w.watched[dir]; ok {
+// addDir watches dir, it returns false if dir is not watched, then its subdirs are not walked.
+func (w *WatchAndRun) addDir(dir string, dfs bool, notifyRun bool) bool {
+	if _, ok := w.watched.get(dir); ok {
 		w.logError("[BUG] duplicated add dir: %s", dir)
 	}
+	if w.watchLimitErr != nil {
+		return false
+	}
+	info := &watchedInfo{file: false, ino: inode(dir)}
+	if w.options.followSymlinks {
+		id, err := dirID(dir)
+		if err != nil {
+			w.logError("watch dir error %s %+v", dir, err)
+			return false
+		}
+		if prev, ok := w.dirIDs[id]; ok {
+			w.logWarn("skip dir %s, it is the same dir as %s (symlink loop?)", dir, prev)
+			return false
+		}
+		w.dirIDs[id] = dir
+		info.id = id
+	}
 	if err := w.watcher.Add(dir); err != nil {
-		w.logError("watch dir error %s %+v", dir, err)
-		return
+		delete(w.dirIDs, info.id)
+		if isWatchLimitError(err) {
+			w.onWatchLimit(dir, err)
+		} else {
+			w.logError("watch dir error %s %+v", dir, err)
+		}
+		return false
 	}
 	w.logChange("watch dir: %s", dir)
-	w.watched[dir] = &watchedInfo{file: false}
+	w.watched.set(dir, info)
+	if r := w.rootOf(dir); r != nil && r.gitIgnorer != nil {
+		// 必须在处理子目录之前加载, 因为子目录是否被忽略取决于它
+		r.gitIgnorer.LoadDir(dir)
+	}
 	if dfs {
-		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-			if dir != path {
+		// 加上分隔符, 这样 dir 是指向目录的 symlink 时也能遍历
+		walkRoot := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+		filepath.WalkDir(walkRoot, func(path string, d fs.DirEntry, err error) error {
+			if walkRoot != path {
 				if d.IsDir() {
-					if !w.shouldWatchDir(path) {
+					if !w.shouldWatchDir(path) || !w.addDir(path, false, notifyRun) {
 						return filepath.SkipDir
 					}
-					w.addDir(path, false, notifyRun)
 				} else {
 					w.maybeAddFile(path, d.Type(), notifyRun)
 				}
@@ -111,84 +241,138 @@ func (w *WatchAndRun) addDir(dir string, dfs bool, notifyRun bool) {
 			return nil
 		})
 	}
+	return true
 }
 
 func (w *WatchAndRun) maybeAddFile(path string, mode fs.FileMode, notifyRun bool) {
-	if (mode&fs.ModeSymlink) == 0 && w.shouldWatchFile(path) {
-		if _, ok := w.watched[path]; ok {
+	if mode&fs.ModeSymlink != 0 {
+		if w.options.followSymlinks {
+			w.addSymlink(path, notifyRun)
+		}
+		return
+	}
+	if w.shouldWatchFile(path) {
+		kind := ChangeModified
+		if info, ok := w.watched.get(path); ok {
 			w.logChange("write file %s", path)
+			// 可能被另一个文件替换了
+			info.ino = inode(path)
 		} else {
 			w.logChange("watch file %s", path)
-			w.watched[path] = &watchedInfo{file: true}
+			w.watched.set(path, &watchedInfo{file: true, ino: inode(path)})
+			kind = ChangeCreated
 		}
 		if notifyRun {
-			w.notifyRun()
+			w.notifyRun(path, kind)
 		}
 	}
 }
 
 func (w *WatchAndRun) shouldWatchDir(path string) bool {
-	// ignore all hidden dirs
-	rel, err := filepath.Rel(w.options.root, path)
-	if err != nil {
-		w.logError("check file error: %+v", err)
-		return false
-	}
-	for {
-		dir, file := filepath.Split(rel)
-		if strings.HasPrefix(file, ".") {
-			return false
-		}
-		if dir == "" {
-			break
-		}
-		rel = dir[:len(dir)-1]
-	}
-	if w.options.ignore != nil {
-		return !w.options.ignore.MatchesPath(path) && !w.options.ignore.MatchesPath(path+"/")
-	}
-	return true
+	return w.checkDir(path).Watched
 }
 
 func (w *WatchAndRun) shouldWatchFile(path string) bool {
-	if strings.HasSuffix(path, "~") {
-		return false
+	return w.checkFile(path).Watched
+}
+
+// onGitIgnoreChanged reloads dir/.gitignore, then watches the entries which are no longer ignored,
+// and unwatches the entries which are ignored now.
+func (w *WatchAndRun) onGitIgnoreChanged(dir string) {
+	r := w.rootOf(dir)
+	if _, ok := w.watched.get(dir); !ok || r == nil || r.gitIgnorer == nil || !r.gitIgnorer.LoadDir(dir) {
+		return
 	}
-	if len(w.options.includeExts) > 0 {
-		ext := filepath.Ext(path)
-		_, ok := w.options.includeExts[ext]
-		if !ok {
+	w.logChange("reload %s", filepath.Join(dir, ".gitignore"))
+	var ignored []string
+	w.watched.walk(dir, func(path string, info *watchedInfo) bool {
+		if path == dir {
+			return true
+		}
+		if ok, _ := r.gitIgnorer.Match(path, !info.file); ok {
+			// 被忽略的目录的整个子树都不再监听
+			ignored = append(ignored, path)
 			return false
 		}
+		return true
+	})
+	for _, path := range ignored {
+		w.unwatch(path, func(path string, info *watchedInfo) {
+			if !info.file {
+				w.watcher.Remove(path)
+			}
+			w.logChange("unwatch ignored %s", path)
+		})
 	}
-	if w.options.ignore != nil {
-		return !w.options.ignore.MatchesPath(path)
-	}
-	return true
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		_, watched := w.watched.get(path)
+		if d.IsDir() {
+			if watched {
+				return nil
+			}
+			if w.shouldWatchDir(path) {
+				w.addDir(path, true, false)
+			}
+			return filepath.SkipDir
+		}
+		if !watched {
+			w.maybeAddFile(path, d.Type(), false)
+		}
+		return nil
+	})
 }
 
 func (w *WatchAndRun) handleLoop() {
 	defer w.watcher.Close()
 	defer w.closeWg.Done()
+	// 轮询和处理事件在同一个协程里, 这样 watched 等状态不需要加锁
+	var pollCh <-chan time.Time
+	if w.options.watchLimitFallback == WatchLimitPoll {
+		ticker := time.NewTicker(w.options.pollInterval)
+		defer ticker.Stop()
+		pollCh = ticker.C
+	}
 	for {
+		var renameCh <-chan time.Time
+		if w.renaming != nil {
+			renameCh = w.renaming.timeout
+		}
 		select {
 		case <-w.closeCh:
 			return
-		case e, ok := <-w.watcher.Events:
+		case <-pollCh:
+			w.poll()
+		case <-renameCh:
+			w.flushRename()
+		case e, ok := <-w.watcher.Events():
 			if !ok {
 				return
 			}
 			w.onFsEvent(e)
-		case err, ok := <-w.watcher.Errors:
+		case err, ok := <-w.watcher.Errors():
 			if !ok {
 				return
 			}
-			log.Fatalf("watcher error %+v", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.rescan()
+				continue
+			}
+			w.logError("watcher error %+v", err)
+			w.fail(fmt.Errorf("watcher error: %w", err))
 		}
 	}
 }
 
 func (w *WatchAndRun) onFsEvent(e fsnotify.Event) {
+	if w.options.gitIgnore && filepath.Base(e.Name) == ".gitignore" {
+		w.onGitIgnoreChanged(filepath.Dir(e.Name))
+	}
+	if w.renaming != nil && w.pairRename(e) {
+		return
+	}
 	// 能不能先判断我们对它是否感兴趣, 如果不感兴趣, 就避免调用 os.stat 了. 不过好在 create 事件不会特别多, 性能还好吧.
 	if e.Has(fsnotify.Create) {
 		// 这里必须用 lstat
@@ -204,42 +388,75 @@ func (w *WatchAndRun) onFsEvent(e fsnotify.Event) {
 	}
 	// 在实践中, write 事件肯定是最多的, 它的处理必须高性能
 	if e.Has(fsnotify.Write) {
-		if _, ok := w.watched[e.Name]; ok {
+		if _, ok := w.watched.get(e.Name); ok {
 			w.logChange("write file %s", e.Name)
-			w.notifyRun()
+			w.notifyRun(e.Name, ChangeModified)
 		}
 	}
 	if e.Has(fsnotify.Remove) || e.Has(fsnotify.Rename) {
-		if info, ok := w.watched[e.Name]; ok {
-			delete(w.watched, e.Name)
-			if info.file {
-				w.logChange("remove file %s", e.Name)
-				w.notifyRun()
+		if info, ok := w.watched.get(e.Name); ok {
+			if e.Has(fsnotify.Rename) {
+				// 先不当作删除, 等待目的地的 create 事件
+				w.renaming = &pendingRename{path: e.Name, info: info, timeout: time.After(renameWindow)}
 			} else {
-				w.logChange("remove dir %s", e.Name)
-				dirPath := e.Name + "/"
-				for path2, info2 := range w.watched {
-					// 有没有更优雅的方式判断 xxx 是 yyy 的子树? 目前我们这里只能遍历
-					if strings.HasPrefix(path2, dirPath) {
-						delete(w.watched, path2)
-						if info2.file {
-							w.logChange("unwatch orphan file %s", path2)
-							w.notifyRun()
-						} else {
-							err := w.watcher.Remove(path2)
-							w.logChange("unwatch orphan dir %s %+v", path2, err)
-						}
-					}
-				}
+				w.removeWatched(e.Name, false)
 			}
 		}
 	}
 }
 
-func (w *WatchAndRun) notifyRun() {
-	if w.options.cancelLast {
+// removeWatched unwatches path and its subtree, the watched files are reported as removed.
+// If moved is true, path still exists somewhere else.
+func (w *WatchAndRun) removeWatched(path string, moved bool) {
+	info, ok := w.watched.get(path)
+	if !ok {
+		return
+	}
+	if info.file {
+		w.logChange("remove file %s", path)
+	} else {
+		w.logChange("remove dir %s (%d watched paths)", path, w.watched.count(path))
+	}
+	// 开销只和子树的大小成正比, 和被监听的路径总数无关
+	w.unwatch(path, func(p string, info *watchedInfo) {
+		switch {
+		case p == path:
+			if info.file {
+				w.notifyRun(p, ChangeRemoved)
+			} else if moved {
+				// 被移走的目录仍然存在, inotify 不会自动移除对它的监听
+				w.watcher.Remove(p)
+			}
+		case info.file:
+			w.logChange("unwatch orphan file %s", p)
+			w.notifyRun(p, ChangeRemoved)
+		default:
+			err := w.watcher.Remove(p)
+			w.logChange("unwatch orphan dir %s %+v", p, err)
+		}
+	})
+}
+
+func (w *WatchAndRun) notifyRun(path string, kind ChangeKind) {
+	w.notifyChange(Change{Path: path, Kind: kind})
+}
+
+func (w *WatchAndRun) notifyChange(c Change) {
+	if w.options.queueMode == QueueDrop && w.running.Load() && !w.live.Load() {
+		w.logChange("drop change during run %s", c.Path)
+		return
+	}
+	w.pendingMu.Lock()
+	w.pending.apply(c)
+	w.pendingTiming = w.timingOf(c.Path)
+	w.pendingMu.Unlock()
+	if w.options.queueMode == QueueRestart && !w.live.Load() {
 		w.cancelRun()
 	}
+	w.triggerRun()
+}
+
+func (w *WatchAndRun) triggerRun() {
 	select {
 	case w.runCh <- struct{}{}:
 	default:
@@ -265,6 +482,7 @@ func (w *WatchAndRun) runLoop() {
 	timer := time.NewTimer(0)
 	timer.Stop()
 	firstRun := true
+	d := &w.debouncer
 	for {
 		select {
 		case <-w.closeCh:
@@ -285,65 +503,271 @@ func (w *WatchAndRun) runLoop() {
 				timer.Reset(0)
 				firstRun = false
 			} else {
-				timer.Reset(w.options.delay)
+				w.pendingMu.Lock()
+				t := w.pendingTiming
+				w.pendingMu.Unlock()
+				timer.Reset(d.next(t, time.Now()))
 			}
 		case <-timer.C:
+			d.runStarted(time.Now())
+			w.running.Store(true)
 			w.runOnce()
+			w.running.Store(false)
+			d.runFinished(time.Now())
+			// 保证最后一次变化之后一定有一次 run: 运行期间累积的 (或 live 进程还没来得及处理的) 变化再触发一次 run
+			w.pendingMu.Lock()
+			if w.pending.len() > 0 {
+				w.triggerRun()
+			}
+			w.pendingMu.Unlock()
 		}
 	}
 }
 
+// takeChanges 取走当前累积的文件变化
+func (w *WatchAndRun) takeChanges() *changeSet {
+	w.pendingMu.Lock()
+	defer w.pendingMu.Unlock()
+	changes := w.pending
+	w.pending = newChangeSet()
+	return changes
+}
+
+// requeueChanges 将被取消的 run 的文件变化放回去, 让下一次 run 能看到它们
+func (w *WatchAndRun) requeueChanges(changes *changeSet) {
+	w.pendingMu.Lock()
+	defer w.pendingMu.Unlock()
+	w.pending.merge(changes)
+}
+
 func (w *WatchAndRun) runOnce() {
-	for _, run := range w.options.run {
-		if err := w.runCmd("Run", run); err != nil {
+	changes := w.takeChanges()
+	list := changes.list()
+	success := true
+	for i, command := range w.options.run {
+		stream := w.options.stream && i == len(w.options.run)-1
+		command.Limits.Timeout = w.commandTimeout(command)
+		if err := w.runCmd("Run", command, list, stream); err != nil {
+			if errors.Is(err, errTimeout) && command.ContinueOnTimeout {
+				w.logWarn("Run: continue after timeout")
+				success = false
+				continue
+			}
+			if errors.Is(err, errCancelled) {
+				w.requeueChanges(changes)
+			} else if errors.Is(err, errRestartRequested) {
+				w.logWarn("Run: restart")
+				w.triggerRun()
+			}
 			return
 		}
 	}
-	w.firstRunSuccess = true
+	if success {
+		w.firstRunSuccess = true
+	}
 }
 
-func (w *WatchAndRun) runCmd(hint string, cmd string) error {
-	execCmd := exec.Command("bash", "-c", cmd)
-	execCmd.Dir = w.options.root
-	execCmd.Env = os.Environ()
-	for key, value := range w.options.env {
-		execCmd.Env = append(execCmd.Env, fmt.Sprintf("%s=%s", key, value))
+// runCmd runs command and waits for it to exit.
+// If stream is true, file changes are written to the stdin of command instead of restarting it.
+// If command has a reload signal, file changes matching its reload rules are reloaded by sending the signal.
+func (w *WatchAndRun) runCmd(hint string, command Command, changes []Change, stream bool) error {
+	changedEnv, cleanup, err := changedEnv(changes)
+	if err != nil {
+		w.logError("%s: write changed files error: %+v", hint, err)
+		return err
 	}
-	if w.options.cfgDir != "" {
-		execCmd.Env = append(execCmd.Env, "WAR_CFG_DIR="+w.options.cfgDir) //
+	defer cleanup()
+	env, err := w.commandEnv()
+	if err != nil {
+		w.logError("%s: load env error: %+v", hint, err)
+		return err
+	}
+	for key, value := range command.Env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 	if !w.firstRunSuccess {
-		execCmd.Env = append(execCmd.Env, "WAR_RUN0=1")
+		env = append(env, "WAR_RUN0=1")
 	}
+	env = append(env, changedEnv...)
+	execCmd, err := newExecCmd(w.commandShell(command), expandChanged(command.Cmd, changes), env)
+	if err != nil {
+		w.logError("%s: parse command error: %+v", hint, err)
+		return err
+	}
+	execCmd.Dir = w.commandDir(command)
+	execCmd.Env = env
 	execCmd.Stdout, execCmd.Stderr = os.Stdout, os.Stderr
+	forwardStdin := w.options.forwardStdin && !stream && command.Stdin == ""
+	if forwardStdin && !w.options.pty {
+		stdin, err := execCmd.StdinPipe()
+		if err != nil {
+			w.logError("%s: stdin pipe error: %+v", hint, err)
+			return err
+		}
+		w.setStdinTarget(stdin)
+		defer w.setStdinTarget(nil)
+	} else if !stream && !w.options.pty {
+		stdin, err := openStdin(command.Stdin, execCmd.Dir)
+		if err != nil {
+			w.logError("%s: open stdin error: %+v", hint, err)
+			return err
+		}
+		if stdin != nil && stdin != os.Stdin {
+			defer stdin.Close()
+		}
+		execCmd.Stdin = stdin
+	}
 	enableProcessGroup(execCmd)
+	var pty *ptyConn
+	if w.options.pty {
+		if pty, err = openPty(execCmd, !stream); err != nil {
+			w.logError("%s: open pty error: %+v", hint, err)
+			return err
+		}
+		defer pty.close()
+		if forwardStdin {
+			w.setStdinTarget(pty)
+			defer w.setStdinTarget(nil)
+		}
+	}
+	// 只有被 stop 的 run 才需要杀掉它所有的子孙进程
+	stopped := false
+	if w.reaper != nil {
+		if err := w.reaper.prepare(execCmd); err != nil {
+			w.logError("%s: prepare reaper error: %+v", hint, err)
+			return err
+		}
+		defer func() { w.reaper.cleanup(hint, execCmd, stopped) }()
+	}
+	var streamCh chan<- []Change
+	if stream {
+		stdin, err := execCmd.StdinPipe()
+		if err != nil {
+			w.logError("%s: stdin pipe error: %+v", hint, err)
+			return err
+		}
+		streamCh = w.startStreamWriter(hint, stdin)
+		defer close(streamCh)
+	}
+	if err := w.waitPortsFree(hint, command.Ports); err != nil {
+		if !errors.Is(err, errCancelled) {
+			w.logError("%s: %+v", hint, err)
+		}
+		return err
+	}
 	begin := time.Now()
-	if err := execCmd.Start(); err != nil {
+	if err := w.startWithLimits(hint, execCmd, command.Limits); err != nil {
 		w.logError("%s: start error: %+v", hint, err)
 		return err
 	}
+	if pty != nil {
+		pty.started()
+	}
 	w.logSuccess("%s: start pid=%d", hint, execCmd.Process.Pid)
 	wait := make(chan error, 1)
 	go func() { wait <- execCmd.Wait() }()
-	select {
-	case cancelReq := <-w.cancelRunCh:
-		killBegin := time.Now()
-		if err := killCmd(hint, execCmd, wait, w.options.termTimeout); err == nil {
-			w.logWarn("%s: cancel run ok, cost=%s", hint, time.Since(killBegin))
-		} else {
-			w.logError("%s: cancel run error: %+v", hint, err)
-		}
-		// 再把这个信号扔进去, 让上层去处理
-		w.cancelRunCh <- cancelReq
-		return errors.New("cancelled")
-	case err := <-wait:
-		if err != nil {
-			w.logError("%s: error %+v", hint, err)
-		} else {
+	var timeoutCh <-chan time.Time
+	if command.Limits.Timeout > 0 {
+		timeoutTimer := time.NewTimer(command.Limits.Timeout)
+		defer timeoutTimer.Stop()
+		timeoutCh = timeoutTimer.C
+	}
+	// live 进程自己处理文件变化 (stream 或 reload), 此时由这里消费 runCh, 否则由 runLoop 处理
+	live := stream || command.ReloadSignal != 0
+	var runCh <-chan struct{}
+	liveTimer := time.NewTimer(0)
+	liveTimer.Stop()
+	if live {
+		runCh = w.runCh
+		w.live.Store(true)
+		defer w.live.Store(false)
+	}
+	for {
+		select {
+		case <-runCh:
+			w.pendingMu.Lock()
+			t := w.pendingTiming
+			w.pendingMu.Unlock()
+			liveTimer.Reset(w.debouncer.next(t, time.Now()))
+		case <-liveTimer.C:
+			// 每次交付给 live 进程都相当于一次 run
+			w.debouncer.runStarted(time.Now())
+			w.debouncer.runFinished(time.Now())
+			if stream {
+				w.streamChanges(hint, streamCh)
+			} else if !w.reloadChanges(hint, command, execCmd) && w.options.queueMode == QueueRestart {
+				w.stopCmd(hint, command, execCmd, wait)
+				stopped = true
+				return errRestartRequested
+			}
+		case <-timeoutCh:
+			w.logError("%s: timeout after %s, stop it", hint, command.Limits.Timeout)
+			w.stopCmd(hint, command, execCmd, wait)
+			stopped = true
+			return errTimeout
+		case cancelReq := <-w.cancelRunCh:
+			w.stopCmd(hint, command, execCmd, wait)
+			stopped = true
+			// 再把这个信号扔进去, 让上层去处理
+			w.cancelRunCh <- cancelReq
+			return errCancelled
+		case err := <-wait:
+			if stream && isRestartRequest(err) {
+				return errRestartRequested
+			}
+			if limitErr := w.limitExceeded(err, command.Limits); limitErr != nil {
+				w.logError("%s: killed, %+v", hint, limitErr)
+				return limitErr
+			}
+			if err != nil {
+				w.logError("%s: error %+v", hint, err)
+			} else {
 
-			w.logSuccess("%s: done, cost=%s", hint, time.Since(begin))
+				w.logSuccess("%s: done, cost=%s", hint, time.Since(begin))
+			}
+			return err
 		}
-		return err
+	}
+}
+
+func (w *WatchAndRun) commandDir(command Command) string {
+	if command.Dir == "" {
+		return w.options.root
+	}
+	if filepath.IsAbs(command.Dir) {
+		return command.Dir
+	}
+	return filepath.Join(w.options.root, command.Dir)
+}
+
+// commandEnv returns the envs of commands: os envs, env files, then inline envs.
+// Env files are re-read every time, so that edits take effect on the next run.
+func (w *WatchAndRun) commandEnv() ([]string, error) {
+	env := os.Environ()
+	fileEnv, err := loadEnvFiles(w.options.envFiles)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, fileEnv...)
+	for key, value := range w.options.env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	if w.options.cfgDir != "" {
+		env = append(env, "WAR_CFG_DIR="+w.options.cfgDir) //
+	}
+	return env, nil
+}
+
+func (w *WatchAndRun) stopCmd(hint string, command Command, execCmd *exec.Cmd, wait <-chan error) {
+	killBegin := time.Now()
+	if command.StopCmd != "" && w.runStopCmd(hint, command, execCmd, wait) {
+		w.logWarn("%s: cancel run ok, cost=%s", hint, time.Since(killBegin))
+		return
+	}
+	if err := killCmd(hint, execCmd, wait, w.stopSteps(), w.options.stopGroup); err == nil {
+		w.logWarn("%s: cancel run ok, cost=%s", hint, time.Since(killBegin))
+	} else {
+		w.logError("%s: cancel run error: %+v", hint, err)
 	}
 }
 
@@ -366,3 +790,44 @@ func (w *WatchAndRun) logWarn(format string, args ...any) {
 func (w *WatchAndRun) logError(format string, args ...any) {
 	log.Println(color.RedString(format, args...))
 }
+
+// runStopCmd runs the stop command of command, and waits for the process to exit.
+// It returns false if the process is still alive after termTimeout.
+func (w *WatchAndRun) runStopCmd(hint string, command Command, execCmd *exec.Cmd, wait <-chan error) bool {
+	env, err := w.commandEnv()
+	if err != nil {
+		w.logError("%s: load env error: %+v", hint, err)
+		return false
+	}
+	env = append(env, fmt.Sprintf("WAR_PID=%d", execCmd.Process.Pid))
+	stopCmd, err := newExecCmd(w.commandShell(command), command.StopCmd, env)
+	if err != nil {
+		w.logError("%s: parse stop cmd error: %+v", hint, err)
+		return false
+	}
+	stopCmd.Dir = w.commandDir(command)
+	stopCmd.Env = env
+	stopCmd.Stdout, stopCmd.Stderr = os.Stdout, os.Stderr
+	w.logWarn("%s: run stop cmd: %s", hint, command.StopCmd)
+	if err := stopCmd.Run(); err != nil {
+		w.logError("%s: stop cmd error: %+v", hint, err)
+	}
+	select {
+	case <-wait:
+		return true
+	case <-time.NewTimer(w.options.termTimeout).C:
+		w.logWarn("%s: still alive after stop cmd, escalate", hint)
+		return false
+	}
+}
+
+// stopSteps returns the configured stop sequence, defaults to SIGTERM, wait termTimeout, then SIGKILL.
+func (w *WatchAndRun) stopSteps() []StopStep {
+	if len(w.options.stopSteps) > 0 {
+		return w.options.stopSteps
+	}
+	if w.options.termTimeout > 0 {
+		return []StopStep{{Signal: syscall.SIGTERM, Timeout: w.options.termTimeout}, {Signal: syscall.SIGKILL}}
+	}
+	return []StopStep{{Signal: syscall.SIGKILL}}
+}
@@ -0,0 +1,142 @@
+package war
+
+import (
+	"path/filepath"
+)
+
+type (
+	// watchedTree holds the watched files and dirs as a tree keyed by path components,
+	// so removing, walking and counting a subtree cost time proportional to the subtree.
+	watchedTree struct {
+		root *watchedNode
+		// nodes 是 path -> node, 包括中间节点, write 事件最多, 查找必须是 O(1) 的
+		nodes map[string]*watchedNode
+	}
+	watchedNode struct {
+		path     string
+		parent   *watchedNode
+		children map[string]*watchedNode
+		// info 为 nil 表示这是一个中间节点, 它本身没有被监听
+		info *watchedInfo
+		// count 是子树中被监听的路径数量, 包括自身
+		count int
+	}
+)
+
+// unwatch removes path and its subtree from watched, fn is called for each removed path.
+// The fsnotify watches of dirs are removed by fn.
+func (w *WatchAndRun) unwatch(path string, fn func(path string, info *watchedInfo)) {
+	w.watched.remove(path, func(path string, info *watchedInfo) {
+		if w.dirIDs[info.id] == path {
+			delete(w.dirIDs, info.id)
+		}
+		if info.symlink {
+			w.watcher.Remove(path)
+		}
+		fn(path, info)
+	})
+}
+
+func newWatchedTree() *watchedTree {
+	return &watchedTree{
+		root:  &watchedNode{},
+		nodes: make(map[string]*watchedNode),
+	}
+}
+
+func (t *watchedTree) get(path string) (*watchedInfo, bool) {
+	n, ok := t.nodes[path]
+	if !ok || n.info == nil {
+		return nil, false
+	}
+	return n.info, true
+}
+
+func (t *watchedTree) set(path string, info *watchedInfo) {
+	n := t.node(path)
+	if n.info == nil {
+		for p := n; p != nil; p = p.parent {
+			p.count++
+		}
+	}
+	n.info = info
+}
+
+// len returns the number of watched paths.
+func (t *watchedTree) len() int {
+	return t.root.count
+}
+
+// count returns the number of watched paths in the subtree of path, including path itself.
+func (t *watchedTree) count(path string) int {
+	if n, ok := t.nodes[path]; ok {
+		return n.count
+	}
+	return 0
+}
+
+// walk calls fn for each watched path in the subtree of path, parents before children.
+// If fn returns false, the subtree of that path is skipped.
+func (t *watchedTree) walk(path string, fn func(path string, info *watchedInfo) bool) {
+	if n, ok := t.nodes[path]; ok {
+		n.walk(fn)
+	}
+}
+
+func (n *watchedNode) walk(fn func(path string, info *watchedInfo) bool) {
+	if n.info != nil && !fn(n.path, n.info) {
+		return
+	}
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+}
+
+// remove removes path and its subtree, fn is called for each removed watched path.
+func (t *watchedTree) remove(path string, fn func(path string, info *watchedInfo)) {
+	n, ok := t.nodes[path]
+	if !ok {
+		return
+	}
+	n.walk(func(path string, info *watchedInfo) bool {
+		fn(path, info)
+		return true
+	})
+	t.forget(n)
+	removed := n.count
+	parent := n.parent
+	delete(parent.children, filepath.Base(n.path))
+	for p := parent; p != nil; p = p.parent {
+		p.count -= removed
+	}
+	// 清理不再需要的中间节点
+	for p := parent; p != t.root && p.info == nil && len(p.children) == 0; p = p.parent {
+		delete(p.parent.children, filepath.Base(p.path))
+		delete(t.nodes, p.path)
+	}
+}
+
+func (t *watchedTree) forget(n *watchedNode) {
+	delete(t.nodes, n.path)
+	for _, child := range n.children {
+		t.forget(child)
+	}
+}
+
+// node returns the node of path, the missing nodes are created.
+func (t *watchedTree) node(path string) *watchedNode {
+	if n, ok := t.nodes[path]; ok {
+		return n
+	}
+	parent := t.root
+	if dir := filepath.Dir(path); dir != path {
+		parent = t.node(dir)
+	}
+	n := &watchedNode{path: path, parent: parent}
+	if parent.children == nil {
+		parent.children = make(map[string]*watchedNode)
+	}
+	parent.children[filepath.Base(path)] = n
+	t.nodes[path] = n
+	return n
+}
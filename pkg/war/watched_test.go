@@ -0,0 +1,87 @@
+package war
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWatchedTree(t *testing.T) {
+	tree := newWatchedTree()
+	tree.set("/r", &watchedInfo{})
+	tree.set("/r/a", &watchedInfo{})
+	tree.set("/r/a/x.go", &watchedInfo{file: true})
+	tree.set("/r/a/b/y.go", &watchedInfo{file: true})
+	tree.set("/r/ab.go", &watchedInfo{file: true})
+	assert.Equal(t, 5, tree.len())
+	assert.Equal(t, 3, tree.count("/r/a"))
+	_, ok := tree.get("/r/a/b")
+	assert.False(t, ok, "intermediate nodes are not watched")
+
+	var removed []string
+	tree.remove("/r/a", func(path string, info *watchedInfo) {
+		removed = append(removed, path)
+	})
+	assert.ElementsMatch(t, []string{"/r/a", "/r/a/x.go", "/r/a/b/y.go"}, removed)
+	assert.Equal(t, 2, tree.len())
+	_, ok = tree.get("/r/ab.go")
+	assert.True(t, ok)
+	_, ok = tree.get("/r/a/x.go")
+	assert.False(t, ok)
+	// 中间节点 /r/a/b 也被清理了
+	assert.Len(t, tree.nodes, 3)
+
+	tree.remove("/r/ab.go", func(string, *watchedInfo) {})
+	tree.remove("/r", func(string, *watchedInfo) {})
+	assert.Equal(t, 0, tree.len())
+	assert.Empty(t, tree.nodes)
+}
+
+// fillWatched watches n files under root, 100 files per dir.
+func fillWatched(n int, set func(path string, info *watchedInfo)) {
+	for i := 0; i < n; i++ {
+		set(filepath.Join("/repo", fmt.Sprintf("pkg%d", i/1000), fmt.Sprintf("dir%d", i/100), fmt.Sprintf("f%d.go", i)), &watchedInfo{file: true})
+	}
+}
+
+// BenchmarkWatchedTreeRemoveSubtree removes and re-adds a dir of 100 files, the cost does not depend on the total size.
+func BenchmarkWatchedTreeRemoveSubtree(b *testing.B) {
+	for _, total := range []int{1000, 200000} {
+		b.Run(fmt.Sprintf("total=%d", total), func(b *testing.B) {
+			tree := newWatchedTree()
+			fillWatched(total, tree.set)
+			dir := filepath.Join("/repo", "pkg0", "dir0")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tree.remove(dir, func(string, *watchedInfo) {})
+				for j := 0; j < 100; j++ {
+					tree.set(filepath.Join(dir, fmt.Sprintf("f%d.go", j)), &watchedInfo{file: true})
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWatchedMapRemoveSubtree is the previous implementation: a prefix scan of the whole map.
+func BenchmarkWatchedMapRemoveSubtree(b *testing.B) {
+	for _, total := range []int{1000, 200000} {
+		b.Run(fmt.Sprintf("total=%d", total), func(b *testing.B) {
+			m := make(map[string]*watchedInfo)
+			fillWatched(total, func(path string, info *watchedInfo) { m[path] = info })
+			dir := filepath.Join("/repo", "pkg0", "dir0")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for path := range m {
+					if strings.HasPrefix(path, dir+"/") {
+						delete(m, path)
+					}
+				}
+				for j := 0; j < 100; j++ {
+					m[filepath.Join(dir, fmt.Sprintf("f%d.go", j))] = &watchedInfo{file: true}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package war
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// BackendInotify watches each dir with fsnotify (inotify on linux).
+	BackendInotify = "inotify"
+	// BackendFanotify marks the whole filesystem once with fanotify and filters events to the watched dirs in user space.
+	// It requires linux >= 5.9 and CAP_SYS_ADMIN, war falls back to BackendInotify if it can not be used.
+	BackendFanotify = "fanotify"
+)
+
+type (
+	// fsWatcher watches dirs and files, events of the direct children of a watched dir are reported.
+	fsWatcher interface {
+		Add(path string) error
+		Remove(path string) error
+		Close() error
+		Events() <-chan fsnotify.Event
+		Errors() <-chan error
+	}
+	fsnotifyWatcher struct {
+		w *fsnotify.Watcher
+	}
+)
+
+// newFsWatcher creates the watcher of backend, it falls back to inotify if fanotify can not be used,
+// fallback is the reason then.
+func newFsWatcher(backend string, root string) (watcher fsWatcher, fallback error, err error) {
+	if backend == BackendFanotify {
+		if watcher, fallback = newFanotifyWatcher(root); fallback == nil {
+			return watcher, nil, nil
+		}
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, newWatcherError(err)
+	}
+	return fsnotifyWatcher{w: w}, fallback, nil
+}
+
+// ValidateBackend checks that backend is inotify or fanotify.
+func ValidateBackend(backend string) error {
+	switch backend {
+	case BackendInotify, BackendFanotify:
+		return nil
+	}
+	return fmt.Errorf("unsupported backend: %s", backend)
+}
+
+func (w fsnotifyWatcher) Add(path string) error         { return w.w.Add(path) }
+func (w fsnotifyWatcher) Remove(path string) error      { return w.w.Remove(path) }
+func (w fsnotifyWatcher) Close() error                  { return w.w.Close() }
+func (w fsnotifyWatcher) Events() <-chan fsnotify.Event { return w.w.Events }
+func (w fsnotifyWatcher) Errors() <-chan error          { return w.w.Errors }
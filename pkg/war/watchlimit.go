@@ -0,0 +1,217 @@
+package war
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// WatchLimitFail stops war if a dir can not be watched because of the inotify limits.
+	WatchLimitFail = "fail"
+	// WatchLimitPoll polls the dirs which can not be watched.
+	WatchLimitPoll = "poll"
+	// WatchLimitDegraded leaves the dirs which can not be watched unwatched.
+	WatchLimitDegraded = "degraded"
+)
+
+type (
+	pollState struct {
+		modTime time.Time
+		size    int64
+	}
+	// subtreeSize is the number of dirs to watch under a root relative dir.
+	subtreeSize struct {
+		rel  string
+		dirs int
+	}
+)
+
+// ValidateWatchLimitFallback checks that fallback is one of fail, poll and degraded.
+func ValidateWatchLimitFallback(fallback string) error {
+	switch fallback {
+	case WatchLimitFail, WatchLimitPoll, WatchLimitDegraded:
+		return nil
+	}
+	return fmt.Errorf("unsupported watch limit fallback: %s", fallback)
+}
+
+// isWatchLimitError reports whether err means the inotify watches are used up (ENOSPC of inotify_add_watch).
+func isWatchLimitError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// newWatcherError explains the error of creating an inotify instance, EMFILE means the inotify instances
+// (or the file descriptors of war) are used up.
+func newWatcherError(err error) error {
+	if !errors.Is(err, syscall.EMFILE) {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString("inotify instance limit reached")
+	if _, instances, ok := readInotifyLimits(); ok {
+		fmt.Fprintf(&sb, ", fs.inotify.max_user_instances=%d (shared by all processes of the user)", instances)
+	}
+	sb.WriteString("\nstop the other watchers or raise the limit: sudo sysctl fs.inotify.max_user_instances=1024")
+	sb.WriteString("\nor war is out of file descriptors, see ulimit -n")
+	return fmt.Errorf("%s\n%w", sb.String(), err)
+}
+
+// onWatchLimit handles a dir which can not be watched because of the inotify limits.
+func (w *WatchAndRun) onWatchLimit(dir string, err error) {
+	if !w.watchLimitReported {
+		w.watchLimitReported = true
+		w.logError("%s", w.watchLimitReport(err))
+	}
+	switch w.options.watchLimitFallback {
+	case WatchLimitFail:
+		if !w.rootWatched {
+			w.watchLimitErr = fmt.Errorf("watch dir %s error: %w", dir, err)
+			return
+		}
+		// 通过 Failed 交给调用方 Stop, 这样正在运行的命令会被正常停止
+		w.fail(fmt.Errorf("watch dir %s error: %w, exit because watch_limit_fallback is fail", dir, err))
+	case WatchLimitPoll:
+		w.logWarn("poll %s every %s", dir, w.options.pollInterval)
+		w.polled[dir] = w.scanPolled(dir)
+	default:
+		w.logWarn("%s and its subdirs are not watched", dir)
+	}
+}
+
+// watchLimitReport describes how many dirs are needed vs. available, and the biggest subtrees to ignore.
+func (w *WatchAndRun) watchLimitReport(err error) string {
+	watchedDirs := 0
+	need := 0
+	var subtrees []subtreeSize
+	for _, r := range w.roots {
+		if r.file {
+			continue
+		}
+		w.watched.walk(r.path, func(path string, info *watchedInfo) bool {
+			if !info.file {
+				watchedDirs++
+			}
+			return true
+		})
+		n, s := w.countDirs(r)
+		need += n
+		subtrees = append(subtrees, s...)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "inotify limit reached (%v): %d dirs need to be watched, %d are watched", err, need, watchedDirs)
+	if watches, instances, ok := readInotifyLimits(); ok {
+		fmt.Fprintf(&sb, ", fs.inotify.max_user_watches=%d (shared by all processes of the user), fs.inotify.max_user_instances=%d", watches, instances)
+	}
+	if len(subtrees) > 0 {
+		sb.WriteString("\nthe biggest subtrees, consider adding them to ignore_rules:")
+		for _, s := range subtrees[:min(5, len(subtrees))] {
+			fmt.Fprintf(&sb, "\n  /%s/ (%d dirs)", s.rel, s.dirs)
+		}
+	}
+	fmt.Fprintf(&sb, "\nor raise the limit: sudo sysctl fs.inotify.max_user_watches=%d", max(need*2, 524288))
+	return sb.String()
+}
+
+// countDirs counts the dirs of r to watch, and returns the subtrees sorted by size (the biggest first).
+// A top level dir is replaced by its subdir if the subdir holds most of its dirs, e.g. web/node_modules.
+func (w *WatchAndRun) countDirs(r *watchRoot) (int, []subtreeSize) {
+	total := 0
+	sizes := make(map[string]int)
+	filepath.WalkDir(r.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == r.path {
+			return nil
+		}
+		if !w.shouldWatchDir(path) {
+			return filepath.SkipDir
+		}
+		total++
+		parts := strings.SplitN(r.rel(path), "/", 3)
+		sizes[parts[0]]++
+		if len(parts) > 1 {
+			sizes[parts[0]+"/"+parts[1]]++
+		}
+		return nil
+	})
+	var ret []subtreeSize
+	for rel, n := range sizes {
+		if strings.Contains(rel, "/") {
+			continue
+		}
+		s := subtreeSize{rel: rel, dirs: n}
+		for child, m := range sizes {
+			if strings.HasPrefix(child, rel+"/") && m*5 >= n*4 {
+				s = subtreeSize{rel: child, dirs: m}
+			}
+		}
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].dirs > ret[j].dirs || (ret[i].dirs == ret[j].dirs && ret[i].rel < ret[j].rel)
+	})
+	if r != w.roots[0] {
+		// 非主 root 的子树带上 root 的路径
+		for i := range ret {
+			ret[i].rel = filepath.ToSlash(filepath.Join(w.roots[0].rel(r.path), ret[i].rel))
+		}
+	}
+	return total, ret
+}
+
+// poll scans the polled dirs and notifies the changed files.
+func (w *WatchAndRun) poll() {
+	for dir, last := range w.polled {
+		current := w.scanPolled(dir)
+		for path, s := range current {
+			if old, ok := last[path]; !ok {
+				w.logChange("poll: create file %s", path)
+				w.notifyRun(path, ChangeCreated)
+			} else if old != s {
+				w.logChange("poll: write file %s", path)
+				w.notifyRun(path, ChangeModified)
+			}
+		}
+		for path := range last {
+			if _, ok := current[path]; !ok {
+				w.logChange("poll: remove file %s", path)
+				w.notifyRun(path, ChangeRemoved)
+			}
+		}
+		w.polled[dir] = current
+		if _, err := os.Stat(dir); err != nil {
+			// 目录被删除了, 如果它被重新创建, 会由父目录的事件重新处理
+			w.logChange("poll: stop polling removed dir %s", dir)
+			delete(w.polled, dir)
+		}
+	}
+}
+
+// scanPolled returns the states of the files to watch under dir.
+func (w *WatchAndRun) scanPolled(dir string) map[string]pollState {
+	ret := make(map[string]pollState)
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && !w.shouldWatchDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !w.shouldWatchFile(path) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			ret[path] = pollState{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return ret
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package war
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readInotifyLimits reads fs.inotify.max_user_watches and fs.inotify.max_user_instances.
+func readInotifyLimits() (watches int, instances int, ok bool) {
+	read := func(name string) (int, bool) {
+		bs, err := os.ReadFile("/proc/sys/fs/inotify/" + name)
+		if err != nil {
+			return 0, false
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+		return n, err == nil
+	}
+	watches, ok1 := read("max_user_watches")
+	instances, ok2 := read("max_user_instances")
+	return watches, instances, ok1 && ok2
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"syscall"
+	"testing"
+)
+
+func TestNewFsWatcherEMFILE(t *testing.T) {
+	// 用完 fd 之后 inotify_init1 和用完 max_user_instances 时一样返回 EMFILE
+	var old syscall.Rlimit
+	assert.NoError(t, syscall.Getrlimit(syscall.RLIMIT_NOFILE, &old))
+	limit := old
+	limit.Cur = 64
+	assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit))
+	defer syscall.Setrlimit(syscall.RLIMIT_NOFILE, &old)
+	for {
+		fd, err := syscall.Dup(0)
+		if err != nil {
+			assert.ErrorIs(t, err, syscall.EMFILE)
+			break
+		}
+		defer syscall.Close(fd)
+	}
+	_, _, err := newFsWatcher(BackendInotify, t.TempDir())
+	assert.ErrorIs(t, err, syscall.EMFILE)
+	assert.Contains(t, err.Error(), "inotify instance limit reached")
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package war
+
+// readInotifyLimits is only supported on linux.
+func readInotifyLimits() (watches int, instances int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,35 @@
+package war
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestCountDirs(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"web/node_modules/a/b", "web/node_modules/c", "web/src", "pkg/x", ".git/objects"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	w, err := NewWatchAndRun(WithRoot(root))
+	assert.NoError(t, err)
+	total, subtrees := w.countDirs(w.roots[0])
+	assert.Equal(t, 8, total)
+	assert.Equal(t, []subtreeSize{{rel: "web", dirs: 6}, {rel: "pkg", dirs: 2}}, subtrees)
+
+	assert.True(t, isWatchLimitError(os.NewSyscallError("inotify_add_watch", syscall.ENOSPC)))
+	assert.False(t, isWatchLimitError(syscall.ENOENT))
+	assert.False(t, isWatchLimitError(os.NewSyscallError("inotify_init1", syscall.EMFILE)))
+}
+
+func TestNewWatcherError(t *testing.T) {
+	// inotify_add_watch 不会返回 EMFILE, max_user_instances 用完时是创建 watcher 失败
+	err := newWatcherError(os.NewSyscallError("inotify_init1", syscall.EMFILE))
+	assert.ErrorIs(t, err, syscall.EMFILE)
+	assert.Contains(t, err.Error(), "sudo sysctl fs.inotify.max_user_instances=")
+
+	other := os.NewSyscallError("inotify_init1", syscall.ENOMEM)
+	assert.Equal(t, other, newWatcherError(other))
+}